@@ -0,0 +1,133 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "os"
+  "strconv"
+  "strings"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+func cellMain(args []string) {
+  flagSet := flag.NewFlagSet("cell", flag.ExitOnError)
+  level := flagSet.Int("level", 30, "Level of the cell when given as latitude and longitude")
+  outputGeojson := flagSet.Bool("geojson", false, "Output the cells as GeoJSON instead of text")
+  shouldIndent := flagSet.Bool("pretty", true, "Output pretty printend GeoJSON")
+  densifyPoints := flagSet.Int("densify", 0, "Add this many interpolated points along each cell edge")
+  flagSet.Usage = func() {
+    fmt.Fprintln(os.Stderr, "Usage: osmcoverer cell [options] <token|id:number|latitude,longitude> ...")
+    flagSet.PrintDefaults()
+  }
+  flagSet.Parse(args)
+  if flagSet.NArg() == 0 {
+    flagSet.Usage()
+    os.Exit(2)
+  }
+
+  cellIds := []s2.CellID{}
+  for _, arg := range flagSet.Args() {
+    cellId, err := parseCellId(arg, *level)
+    check(err)
+    cellIds = append(cellIds, cellId)
+  }
+
+  if *outputGeojson {
+    featureCollection := geojson.NewFeatureCollection()
+    for _, cellId := range cellIds {
+      featureCollection.AddFeature(getCellFeatureFromCellId(cellId, EdgeDensify{points: *densifyPoints}))
+    }
+    var outputGeojsonData []byte
+    var err error
+    if *shouldIndent {
+      outputGeojsonData, err = json.MarshalIndent(featureCollection, "", " ")
+    } else {
+      outputGeojsonData, err = featureCollection.MarshalJSON()
+    }
+    check(err)
+    fmt.Println(string(outputGeojsonData))
+    return
+  }
+
+  for index, cellId := range cellIds {
+    if index > 0 {
+      fmt.Println("")
+    }
+    printCellInfo(cellId)
+  }
+}
+
+
+// Accepts a cell id token, a numeric cell id or a latitude,longitude pair.
+// Short numeric ids such as 4503599627370496 and tokens like 4691880 both consist of digits,
+// so numeric ids can be given as id:<number>. Without the prefix, only text longer
+// than the 16 characters a token can have is parsed as a numeric id.
+func parseCellId(text string, level int) (s2.CellID, error) {
+  var cellId s2.CellID
+  if strings.Contains(text, ",") {
+    values, err := parseFloatList(text, 2)
+    if err != nil {
+      return cellId, fmt.Errorf("invalid latitude,longitude %q: %v", text, err)
+    }
+    if level < 0 || level > 30 {
+      return cellId, fmt.Errorf("level %d is not between 0 and 30", level)
+    }
+    cellId = s2.CellIDFromLatLng(s2.LatLngFromDegrees(values[0], values[1])).Parent(level)
+  } else if strings.HasPrefix(text, "id:") || len(text) > 16 {
+    idText := strings.TrimPrefix(text, "id:")
+    value, err := strconv.ParseUint(idText, 10, 64)
+    if err != nil {
+      // Signed ids, as in ranges.csv and SQLite, are negative on faces 4 and 5
+      signedValue, signedErr := strconv.ParseInt(idText, 10, 64)
+      if signedErr != nil {
+        return cellId, fmt.Errorf("invalid cell id %q", text)
+      }
+      value = uint64(signedValue)
+    }
+    cellId = s2.CellID(value)
+  } else {
+    cellId = s2.CellIDFromToken(text)
+  }
+  if ! cellId.IsValid() {
+    return cellId, fmt.Errorf("invalid cell %q", text)
+  }
+  return cellId, nil
+}
+
+
+func printCellInfo(cellId s2.CellID) {
+  cell := s2.CellFromCellID(cellId)
+  center := s2.LatLngFromPoint(cell.Center())
+  fmt.Println("Token:", cellId.ToToken())
+  fmt.Println("Id:", uint64(cellId))
+  fmt.Println("Level:", cellId.Level())
+  fmt.Println("Face:", cellId.Face())
+  fmt.Println("Center:", formatLatLng(center))
+  fmt.Println("Area:", fmt.Sprintf("%.2f m²", cell.ExactArea() * earthRadiusMeters * earthRadiusMeters))
+  fmt.Println("Vertices:")
+  for k := 0; k < 4; k++ {
+    fmt.Println(" ", formatLatLng(s2.LatLngFromPoint(cell.Vertex(k))))
+  }
+  fmt.Println("Parents:")
+  for level := cellId.Level() - 1; level >= 0; level-- {
+    fmt.Println(" ", level, cellId.Parent(level).ToToken())
+  }
+  if ! cellId.IsLeaf() {
+    fmt.Println("Children:")
+    for _, child := range cellId.Children() {
+      fmt.Println(" ", child.ToToken())
+    }
+  }
+  fmt.Println("Edge neighbors:")
+  for _, neighbor := range cellId.EdgeNeighbors() {
+    fmt.Println(" ", neighbor.ToToken())
+  }
+}
+
+
+func formatLatLng(latlng s2.LatLng) string {
+  return fmt.Sprintf("%s,%s", strconv.FormatFloat(latlng.Lat.Degrees(), 'f', -1, 64), strconv.FormatFloat(latlng.Lng.Degrees(), 'f', -1, 64))
+}
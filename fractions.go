@@ -0,0 +1,125 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+// How many levels below a cell are examined when estimating its area within a polygon.
+// Cells at the deepest level count as inside if their center is.
+const fractionDepth = 6
+
+
+// Like getCoveredFeature, but the covering consists of the cells of the given level
+// with more than minFraction of their area within the Feature. Holes are covered as usual.
+func getFractionCoveredFeature(feature *geojson.Feature, fractionLevel int, minFraction float64, maxCells int, densify EdgeDensify) (CoveredFeature, error) {
+  cellIds := s2.CellUnion{}
+  cellFractions := []float64{}
+  var fractionDuration time.Duration
+  if getRoleForFeature(feature) != "inner" {
+    polygons := getS2PolygonsWithHolesFromFeature(feature, []*s2.Loop{})
+    start := time.Now()
+    var err error
+    cellIds, cellFractions, err = getFractionCellIds(polygons, fractionLevel, minFraction, maxCells)
+    if err != nil {
+      return CoveredFeature{}, fmt.Errorf("%s has %v, use a higher -fractionlevel or -maxgridcells", getPathForFeature(feature), err)
+    }
+    fractionDuration = time.Since(start)
+  }
+  coveredFeature := getCoveredFeatureFromCellIds(feature, fractionLevel, cellIds, cellFractions, maxCells, densify)
+  coveredFeature.coveringDuration += fractionDuration
+  return coveredFeature, nil
+}
+
+
+// Builds a CoveredFeature with the given cells as its covering. Inner Features and holes
+// are covered with interior coverings of the given level instead, and only those
+// are included in the covering duration.
+func getCoveredFeatureFromCellIds(feature *geojson.Feature, level int, cellIds s2.CellUnion, cellFractions []float64, maxCells int, densify EdgeDensify) CoveredFeature {
+  var coveredFeature CoveredFeature
+  coveredFeature.feature = feature
+  coveredFeature.name = getNameForFeature(feature)
+  coveredFeature.isHole = getRoleForFeature(feature) == "inner"
+  coveredFeature.polygons, coveredFeature.holePolygons = getS2PolygonsFromFeature(feature)
+  if coveredFeature.isHole {
+    polygonCoverings, coveringDuration := getPolygonCoverings(coveredFeature.polygons, true, level, level, maxCells)
+    coveredFeature.covering, coveredFeature.cellIds, coveredFeature.cellGeometry = getCoveringFromPolygonCoverings(polygonCoverings, densify)
+    coveredFeature.coveringDuration = coveringDuration
+  } else {
+    coveredFeature.covering = &cellIds
+    coveredFeature.cellIds, coveredFeature.cellGeometry = getGeojsonMultiPolygonFromCellUnion(cellIds, densify)
+    coveredFeature.cellFractions = cellFractions
+  }
+  holePolygonCoverings, holeCoveringDuration := getPolygonCoverings(coveredFeature.holePolygons, true, level, level, maxCells)
+  coveredFeature.holeCovering, coveredFeature.holeCellIds, coveredFeature.holeCellGeometry = getCoveringFromPolygonCoverings(holePolygonCoverings, densify)
+  coveredFeature.coveringDuration += holeCoveringDuration
+  return coveredFeature
+}
+
+
+// Returns the cells of the given level with more than minFraction of their area within
+// the polygons, and the fraction of each. The polygons include their holes, so that an
+// island within a hole of another part is not cut away. The cells are in cell id order.
+func getFractionCellIds(polygons []*s2.Polygon, fractionLevel int, minFraction float64, maxCells int) (s2.CellUnion, []float64, error) {
+  candidates := s2.CellUnion{}
+  for _, polygon := range polygons {
+    polygonCellIds, err := getGridCellIdsFromRegion(polygon, fractionLevel, maxCells)
+    if err != nil {
+      return nil, nil, err
+    }
+    candidates = s2.CellUnionFromUnion(candidates, polygonCellIds)
+  }
+  // The union replaces complete sets of siblings with their parent
+  candidates.Denormalize(fractionLevel, 1)
+  cellIds := s2.CellUnion{}
+  cellFractions := []float64{}
+  for _, cellId := range candidates {
+    fraction := getCellFractionWithinPolygons(s2.CellFromCellID(cellId), polygons, fractionDepth)
+    if fraction > minFraction {
+      cellIds = append(cellIds, cellId)
+      cellFractions = append(cellFractions, fraction)
+    }
+  }
+  return cellIds, cellFractions, nil
+}
+
+
+// Estimates the fraction of the cell's area within the polygons, by dividing cells
+// crossing polygon edges into children down to the given depth.
+func getCellFractionWithinPolygons(cell s2.Cell, polygons []*s2.Polygon, depth int) float64 {
+  intersectsPolygon := false
+  for _, polygon := range polygons {
+    if polygon.ContainsCell(cell) {
+      return 1
+    }
+    intersectsPolygon = intersectsPolygon || polygon.IntersectsCell(cell)
+  }
+  if ! intersectsPolygon {
+    return 0
+  }
+  if depth == 0 || cell.ID().IsLeaf() {
+    if isPointWithinPolygons(cell.Center(), polygons) {
+      return 1
+    }
+    return 0
+  }
+  areaWithin := 0.0
+  for _, childId := range cell.ID().Children() {
+    child := s2.CellFromCellID(childId)
+    areaWithin += getCellFractionWithinPolygons(child, polygons, depth - 1) * child.ExactArea()
+  }
+  return areaWithin / cell.ExactArea()
+}
+
+
+func isPointWithinPolygons(point s2.Point, polygons []*s2.Polygon) bool {
+  for _, polygon := range polygons {
+    if polygon.ContainsPoint(point) {
+      return true
+    }
+  }
+  return false
+}
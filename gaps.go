@@ -0,0 +1,78 @@
+package main
+
+import (
+  "fmt"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+// Divides the regions into cells of the given level, and returns them along with the
+// cells not fully within the Feature coverings and the fraction of each left uncovered.
+// Parts of the region cells outside the regions are not reported as gaps.
+func getCoverageGaps(regions []s2.Region, gapLevel int, maxCells int, featuresCovering s2.CellUnion) (s2.CellUnion, s2.CellUnion, []float64, error) {
+  regionCells := s2.CellUnion{}
+  for _, region := range regions {
+    cellIds, err := getGridCellIdsFromRegion(region, gapLevel, maxCells)
+    if err != nil {
+      return nil, nil, nil, fmt.Errorf("gap region has %v, use a higher -gaplevel or -maxgapcells", err)
+    }
+    regionCells = s2.CellUnionFromUnion(regionCells, cellIds)
+  }
+  // The union replaces complete sets of siblings with their parent
+  regionCells.Denormalize(gapLevel, 1)
+  if len(regionCells) > maxCells {
+    return nil, nil, nil, fmt.Errorf("gap region has more than %d level %d cells, use a higher -gaplevel or -maxgapcells", maxCells, gapLevel)
+  }
+  uncoveredCells := s2.CellUnionFromDifference(regionCells, featuresCovering)
+  uncoveredCells.Denormalize(gapLevel, 1)
+  uncoveredAreas := map[s2.CellID]float64{}
+  for _, cellId := range uncoveredCells {
+    uncoveredAreas[cellId.Parent(gapLevel)] += s2.CellFromCellID(cellId).ExactArea()
+  }
+  gapCells := s2.CellUnion{}
+  gapFractions := []float64{}
+  for _, cellId := range regionCells {
+    if uncoveredAreas[cellId] == 0 {
+      continue
+    }
+    cell := s2.CellFromCellID(cellId)
+    for _, region := range regions {
+      if region.IntersectsCell(cell) {
+        gapCells = append(gapCells, cellId)
+        gapFractions = append(gapFractions, uncoveredAreas[cellId] / cell.ExactArea())
+        break
+      }
+    }
+  }
+  return regionCells, gapCells, gapFractions, nil
+}
+
+
+func getGapAreaMeters(gapCells s2.CellUnion, gapFractions []float64) float64 {
+  area := 0.0
+  for index, cellId := range gapCells {
+    area += s2.CellFromCellID(cellId).ExactArea() * gapFractions[index]
+  }
+  return area * earthRadiusMeters * earthRadiusMeters
+}
+
+
+func getCoverageGapsFeatureCollection(gapCells s2.CellUnion, gapFractions []float64, regionArea float64, gapArea float64, gapColor string, densify EdgeDensify) *geojson.FeatureCollection {
+  featureCollection := geojson.NewFeatureCollection()
+  if len(gapCells) == 0 {
+    return featureCollection
+  }
+  cellIds, cellGeometry := getGeojsonMultiPolygonFromCellUnion(gapCells, densify)
+  feature := geojson.NewMultiPolygonFeature(cellGeometry...)
+  feature.SetProperty("cellids", cellIds)
+  feature.SetProperty("gapfractions", gapFractions)
+  feature.SetProperty("regionarea", regionArea)
+  feature.SetProperty("gaparea", gapArea)
+  feature.SetProperty("stroke", gapColor)
+  feature.SetProperty("stroke-width", 1)
+  feature.SetProperty("fill", gapColor)
+  feature.SetProperty("fill-opacity", 0.3)
+  featureCollection.AddFeature(feature)
+  return featureCollection
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+  "database/sql"
+  "encoding/csv"
+  "flag"
+  "fmt"
+  "os"
+  "strconv"
+  "github.com/golang/geo/s2"
+)
+
+
+const lookupQuery = `
+SELECT features.path, MAX(cell_ranges.is_hole)
+FROM cell_ranges JOIN features ON features.id = cell_ranges.feature_id
+WHERE ? BETWEEN cell_ranges.range_min AND cell_ranges.range_max
+GROUP BY features.id
+ORDER BY features.id`
+
+
+// Looks up points from the SQLite database written with -sqlite.
+// Writes one CSV row per matching Feature to stdout, or a row with an empty path
+// if the point is not within any Feature.
+func lookupMain(args []string) {
+  flagSet := flag.NewFlagSet("lookup", flag.ExitOnError)
+  indexFilePath := flagSet.String("index", "", "SQLite database written with -sqlite")
+  pointsFilePath := flagSet.String("points", "", "CSV of points. Format: <name>,<latitude>,<longitude>")
+  flagSet.Usage = func() {
+    fmt.Fprintln(os.Stderr, "Usage: osmcoverer lookup -index <file> [-points <file>] [<latitude>,<longitude> ...]")
+    flagSet.PrintDefaults()
+  }
+  flagSet.Parse(args)
+  if *indexFilePath == "" {
+    flagSet.Usage()
+    os.Exit(2)
+  }
+  // Opening a missing SQLite file would create an empty database
+  _, err := os.Stat(*indexFilePath)
+  check(err)
+
+  db, err := sql.Open("sqlite", *indexFilePath)
+  check(err)
+  defer db.Close()
+  statement, err := db.Prepare(lookupQuery)
+  check(err)
+  defer statement.Close()
+
+  writer := csv.NewWriter(os.Stdout)
+  defer writer.Flush()
+  err = writer.Write([]string{"name", "latitude", "longitude", "path", "ishole"})
+  check(err)
+  for _, arg := range flagSet.Args() {
+    values, err := parseFloatList(arg, 2)
+    if err != nil {
+      check(fmt.Errorf("invalid point %q: %v", arg, err))
+    }
+    writeLookupRows(writer, statement, arg, values[0], values[1])
+  }
+  if *pointsFilePath != "" {
+    for _, row := range readCsv(*pointsFilePath) {
+      lat, err := strconv.ParseFloat(row[1], 64)
+      check(err)
+      lng, err := strconv.ParseFloat(row[2], 64)
+      check(err)
+      writeLookupRows(writer, statement, row[0], lat, lng)
+    }
+  }
+}
+
+
+func writeLookupRows(writer *csv.Writer, statement *sql.Stmt, name string, lat float64, lng float64) {
+  cellId := s2.CellIDFromLatLng(s2.LatLngFromDegrees(lat, lng))
+  rows, err := statement.Query(int64(cellId))
+  check(err)
+  defer rows.Close()
+  latText, lngText := strconv.FormatFloat(lat, 'f', -1, 64), strconv.FormatFloat(lng, 'f', -1, 64)
+  found := false
+  for rows.Next() {
+    var path string
+    var isHole bool
+    err = rows.Scan(&path, &isHole)
+    check(err)
+    err = writer.Write([]string{name, latText, lngText, path, strconv.FormatBool(isHole)})
+    check(err)
+    found = true
+  }
+  check(rows.Err())
+  if ! found {
+    err = writer.Write([]string{name, latText, lngText, "", ""})
+    check(err)
+  }
+}
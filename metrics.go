@@ -0,0 +1,132 @@
+package main
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+// Areas are in square meters and exclude holes. Over-coverage is the covering area divided by the polygon area.
+type CoveringMetrics struct {
+  polygonArea float64
+  coveringArea float64
+  interiorCoveringArea float64
+  cells int
+  holeCells int
+  cellsByLevel map[int]int
+  coveringDuration time.Duration
+}
+
+
+var coveringMetricsCsvHeader = []string{"path", "name", "polygonarea", "coveringarea", "interiorcoveringarea", "overcoverage", "cells", "holecells", "cellsbylevel", "coveringms"}
+
+
+func getCoveringMetrics(coveredFeature CoveredFeature, maxLevel int, minLevel int, maxCells int) CoveringMetrics {
+  metrics := CoveringMetrics{cellsByLevel: map[int]int{}}
+  for _, polygon := range coveredFeature.polygons {
+    metrics.polygonArea += polygon.Area()
+  }
+  for _, polygon := range coveredFeature.holePolygons {
+    metrics.polygonArea -= polygon.Area()
+  }
+  metrics.polygonArea *= earthRadiusMeters * earthRadiusMeters
+  // The hole covering is an interior covering, so the covering minus it still contains the polygon.
+  // Subtracting the full covering of the holes from the interior covering keeps it inside the polygon.
+  metrics.coveringArea = getCellUnionAreaMeters(s2.CellUnionFromDifference(*coveredFeature.covering, *coveredFeature.holeCovering))
+  interiorCovering, _, _ := getCoveringFromPolygons(coveredFeature.polygons, true, maxLevel, minLevel, maxCells, EdgeDensify{})
+  holeCovering, _, _ := getCoveringFromPolygons(coveredFeature.holePolygons, false, maxLevel, minLevel, maxCells, EdgeDensify{})
+  metrics.interiorCoveringArea = getCellUnionAreaMeters(s2.CellUnionFromDifference(*interiorCovering, *holeCovering))
+  metrics.cells = len(coveredFeature.cellIds)
+  metrics.holeCells = len(coveredFeature.holeCellIds)
+  for _, cellToken := range coveredFeature.cellIds {
+    metrics.cellsByLevel[s2.CellIDFromToken(cellToken).Level()]++
+  }
+  metrics.coveringDuration = coveredFeature.coveringDuration
+  return metrics
+}
+
+
+func getOverCoverage(metrics CoveringMetrics) float64 {
+  if metrics.polygonArea <= 0 {
+    return 0
+  }
+  return metrics.coveringArea / metrics.polygonArea
+}
+
+
+// Sums metrics for the summary of all Features.
+func addCoveringMetrics(total CoveringMetrics, metrics CoveringMetrics) CoveringMetrics {
+  total.polygonArea += metrics.polygonArea
+  total.coveringArea += metrics.coveringArea
+  total.interiorCoveringArea += metrics.interiorCoveringArea
+  total.cells += metrics.cells
+  total.holeCells += metrics.holeCells
+  for level, count := range metrics.cellsByLevel {
+    total.cellsByLevel[level] += count
+  }
+  total.coveringDuration += metrics.coveringDuration
+  return total
+}
+
+
+func setCoveringMetricsProperties(feature *geojson.Feature, metrics CoveringMetrics) {
+  cellsByLevel := map[string]int{}
+  for level, count := range metrics.cellsByLevel {
+    cellsByLevel[strconv.Itoa(level)] = count
+  }
+  feature.SetProperty("polygonarea", metrics.polygonArea)
+  feature.SetProperty("coveringarea", metrics.coveringArea)
+  feature.SetProperty("interiorcoveringarea", metrics.interiorCoveringArea)
+  feature.SetProperty("overcoverage", getOverCoverage(metrics))
+  feature.SetProperty("cellsbylevel", cellsByLevel)
+  feature.SetProperty("coveringms", metrics.coveringDuration.Seconds() * 1000)
+}
+
+
+func getCoveringMetricsRow(path string, name string, metrics CoveringMetrics) []string {
+  return []string{
+    path,
+    name,
+    strconv.FormatFloat(metrics.polygonArea, 'f', 2, 64),
+    strconv.FormatFloat(metrics.coveringArea, 'f', 2, 64),
+    strconv.FormatFloat(metrics.interiorCoveringArea, 'f', 2, 64),
+    strconv.FormatFloat(getOverCoverage(metrics), 'f', 4, 64),
+    strconv.Itoa(metrics.cells),
+    strconv.Itoa(metrics.holeCells),
+    formatCellsByLevel(metrics.cellsByLevel),
+    strconv.FormatFloat(metrics.coveringDuration.Seconds() * 1000, 'f', 3, 64)}
+}
+
+
+// Formats the histogram as space separated level:count pairs in level order.
+func formatCellsByLevel(cellsByLevel map[int]int) string {
+  levels := []int{}
+  for level := range cellsByLevel {
+    levels = append(levels, level)
+  }
+  sort.Ints(levels)
+  parts := []string{}
+  for _, level := range levels {
+    parts = append(parts, fmt.Sprintf("%d:%d", level, cellsByLevel[level]))
+  }
+  return strings.Join(parts, " ")
+}
+
+
+func printCoveringMetricsSummary(total CoveringMetrics, featureCount int) {
+  fmt.Println("")
+  fmt.Println("Features:", featureCount)
+  fmt.Println("Polygon area:", fmt.Sprintf("%.0f m²", total.polygonArea))
+  fmt.Println("Covering area:", fmt.Sprintf("%.0f m²", total.coveringArea))
+  fmt.Println("Interior covering area:", fmt.Sprintf("%.0f m²", total.interiorCoveringArea))
+  fmt.Println("Over-coverage:", fmt.Sprintf("%.4f", getOverCoverage(total)))
+  fmt.Println("Cells:", total.cells)
+  fmt.Println("Hole cells:", total.holeCells)
+  fmt.Println("Cells by level:", formatCellsByLevel(total.cellsByLevel))
+  fmt.Println("Covering time:", total.coveringDuration)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+  "fmt"
+  "github.com/paulmach/go.geojson"
+)
+
+
+type RelationRings struct {
+  id int
+  properties map[string]interface{}
+  outerWays [][][]float64
+  innerWays [][][]float64
+}
+
+
+// Replaces the outer and inner member ways of each relation with a single MultiPolygon
+// Feature of the relation. Members of a relation that cannot be assembled are kept as they are.
+func getAssembledFeatureCollection(featureCollection *geojson.FeatureCollection) *geojson.FeatureCollection {
+  relations := []*RelationRings{}
+  relationsById := map[int]*RelationRings{}
+  for _, feature := range featureCollection.Features {
+    way := getWayCoordinates(feature)
+    if way == nil {
+      continue
+    }
+    for _, membership := range getRelationMembershipsForFeature(feature) {
+      if membership.role != "outer" && membership.role != "inner" {
+        continue
+      }
+      relationRings, exists := relationsById[membership.id]
+      if ! exists {
+        relationRings = &RelationRings{id: membership.id, properties: map[string]interface{}{}}
+        for key, value := range membership.tags {
+          relationRings.properties[key] = value
+        }
+        relationsById[membership.id] = relationRings
+        relations = append(relations, relationRings)
+      }
+      if membership.role == "inner" {
+        relationRings.innerWays = append(relationRings.innerWays, way)
+      } else {
+        relationRings.outerWays = append(relationRings.outerWays, way)
+      }
+    }
+  }
+
+  assembledRelations := map[int]bool{}
+  assembledFeatures := []*geojson.Feature{}
+  for _, relationRings := range relations {
+    multiPolygon := getMultiPolygonFromRelationRings(relationRings)
+    if len(multiPolygon) == 0 {
+      fmt.Println("Could not assemble", fmt.Sprintf("relation/%d", relationRings.id) + ", keeping its member ways")
+      continue
+    }
+    feature := geojson.NewMultiPolygonFeature(multiPolygon...)
+    feature.ID = fmt.Sprintf("relation/%d", relationRings.id)
+    feature.Properties = relationRings.properties
+    assembledRelations[relationRings.id] = true
+    assembledFeatures = append(assembledFeatures, feature)
+  }
+
+  assembledFeatureCollection := geojson.NewFeatureCollection()
+  for _, feature := range featureCollection.Features {
+    if ! isAssembledMember(feature, assembledRelations) {
+      assembledFeatureCollection.AddFeature(feature)
+    }
+  }
+  for _, feature := range assembledFeatures {
+    assembledFeatureCollection.AddFeature(feature)
+  }
+  fmt.Println("Assembled relations:", len(assembledFeatures))
+  return assembledFeatureCollection
+}
+
+
+// Ways are LineStrings, or Polygons without holes for closed ways.
+// Returns nil for Features that are not relation member ways.
+func getWayCoordinates(feature *geojson.Feature) [][]float64 {
+  if feature.Properties["@relations"] == nil {
+    return nil
+  }
+  if feature.Geometry.IsLineString() {
+    return feature.Geometry.LineString
+  }
+  if feature.Geometry.IsPolygon() && len(feature.Geometry.Polygon) == 1 {
+    return feature.Geometry.Polygon[0]
+  }
+  return nil
+}
+
+
+// A Feature is replaced only when every relation it belongs to has been assembled.
+func isAssembledMember(feature *geojson.Feature, assembledRelations map[int]bool) bool {
+  if getWayCoordinates(feature) == nil {
+    return false
+  }
+  for _, membership := range getRelationMembershipsForFeature(feature) {
+    if ! assembledRelations[membership.id] {
+      return false
+    }
+  }
+  return true
+}
+
+
+// Stitches the ways into rings and places each inner ring in the outer ring containing it.
+// Returns nil if any way is left out of a closed ring, or any inner ring is outside
+// the outer rings, so that no member geometry is lost.
+func getMultiPolygonFromRelationRings(relationRings *RelationRings) [][][][]float64 {
+  outerRings, unclosedOuterWays := getRingsFromWays(relationRings.outerWays)
+  innerRings, unclosedInnerWays := getRingsFromWays(relationRings.innerWays)
+  if unclosedOuterWays + unclosedInnerWays > 0 {
+    fmt.Println("Unclosed ways in", fmt.Sprintf("relation/%d:", relationRings.id), unclosedOuterWays, "outer,", unclosedInnerWays, "inner")
+    return nil
+  }
+  if len(outerRings) == 0 {
+    return nil
+  }
+  multiPolygon := [][][][]float64{}
+  for _, outerRing := range outerRings {
+    multiPolygon = append(multiPolygon, [][][]float64{outerRing})
+  }
+  for _, innerRing := range innerRings {
+    innerLoop := getS2LoopFromGeojsonRing(innerRing)
+    placed := false
+    for index, polygon := range multiPolygon {
+      if getS2LoopFromGeojsonRing(polygon[0]).Contains(innerLoop) {
+        multiPolygon[index] = append(polygon, innerRing)
+        placed = true
+        break
+      }
+    }
+    if ! placed {
+      fmt.Println("Inner ring outside outer rings in", fmt.Sprintf("relation/%d", relationRings.id))
+      return nil
+    }
+  }
+  return multiPolygon
+}
+
+
+// Joins ways sharing end nodes into closed rings. Returns the rings
+// and the number of ways left in rings that could not be closed.
+func getRingsFromWays(ways [][][]float64) ([][][]float64, int) {
+  rings := [][][]float64{}
+  openWays := [][][]float64{}
+  unclosedWays := 0
+  for _, way := range ways {
+    if len(way) < 2 {
+      unclosedWays++
+      continue
+    }
+    if isClosedWay(way) {
+      rings = append(rings, way)
+    } else {
+      openWays = append(openWays, way)
+    }
+  }
+  for len(openWays) > 0 {
+    ring := append([][]float64{}, openWays[0]...)
+    openWays = openWays[1:]
+    joinedWays := 1
+    for ! isClosedWay(ring) {
+      joined := false
+      for index, way := range openWays {
+        end := ring[len(ring) - 1]
+        if isSamePosition(way[0], end) {
+          ring = append(ring, way[1:]...)
+        } else if isSamePosition(way[len(way) - 1], end) {
+          reversed := reverseWay(way)
+          ring = append(ring, reversed[1:]...)
+        } else {
+          continue
+        }
+        openWays = append(openWays[:index], openWays[index + 1:]...)
+        joinedWays++
+        joined = true
+        break
+      }
+      if ! joined {
+        break
+      }
+    }
+    if isClosedWay(ring) {
+      rings = append(rings, ring)
+    } else {
+      unclosedWays += joinedWays
+    }
+  }
+  return rings, unclosedWays
+}
+
+
+func isClosedWay(way [][]float64) bool {
+  return len(way) >= 4 && isSamePosition(way[0], way[len(way) - 1])
+}
+
+
+func isSamePosition(a []float64, b []float64) bool {
+  return a[0] == b[0] && a[1] == b[1]
+}
+
+
+func reverseWay(way [][]float64) [][]float64 {
+  reversed := make([][]float64, len(way))
+  for index, position := range way {
+    reversed[len(way) - 1 - index] = position
+  }
+  return reversed
+}
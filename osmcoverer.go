@@ -1,6 +1,7 @@
 package main
 
 import (
+  "database/sql"
   "flag"
   "fmt"
   "os"
@@ -10,11 +11,65 @@ import (
   "encoding/csv"
   "io/ioutil"
   "path/filepath"
+  "sort"
+  "time"
+  "github.com/golang/geo/s1"
   "github.com/golang/geo/s2"
   "github.com/paulmach/go.geojson"
 )
 
 
+const earthRadiusMeters = 6371010.0
+
+
+// Settings for adding points along cell edges, so that the geodesic
+// edges of S2 cells are drawn correctly by planar lat/lng renderers.
+// The zero value outputs only the cell vertices.
+type EdgeDensify struct {
+  points int
+  maxError float64
+}
+
+
+// Inclusive range of leaf cell ids, as given by CellID.RangeMin and RangeMax.
+type CellRange struct {
+  min s2.CellID
+  max s2.CellID
+}
+
+
+type CoveredFeature struct {
+  feature *geojson.Feature
+  name string
+  isHole bool
+  polygons []*s2.Polygon
+  holePolygons []*s2.Polygon
+  covering *s2.CellUnion
+  cellIds []string
+  cellGeometry [][][][]float64
+  holeCovering *s2.CellUnion
+  holeCellIds []string
+  holeCellGeometry [][][][]float64
+  // Fraction of each cell's area within the Feature, only set for fraction coverings
+  cellFractions []float64
+  coveringDuration time.Duration
+}
+
+
+type RelationMembership struct {
+  id int
+  role string
+  name string
+  tags map[string]interface{}
+}
+
+
+type InputCell struct {
+  cellId s2.CellID
+  feature *geojson.Feature
+}
+
+
 type Marker struct {
   cellId *s2.CellID
   cellAtLevel *s2.Cell
@@ -23,43 +78,138 @@ type Marker struct {
 
 
 func main() {
+  if len(os.Args) > 1 {
+    switch os.Args[1] {
+    case "lookup":
+      lookupMain(os.Args[2:])
+      return
+    case "serve":
+      serveMain(os.Args[2:])
+      return
+    case "cell":
+      cellMain(os.Args[2:])
+      return
+    case "setops":
+      setopsMain(os.Args[2:])
+      return
+    case "sweep":
+      sweepMain(os.Args[2:])
+      return
+    case "validate":
+      validateMain(os.Args[2:])
+      return
+    }
+  }
+
   // Set up
   outputSeparateFiles := flag.Bool("separate", false, "Output Features into separate files")
   skipMarkerlessFeatures := flag.Bool("skipmarkerless", false, "Skip features with no markers within")
   skipFeaturelessMarkers := flag.Bool("skipfeatureless", false, "Skip markers not within features")
   excludeCellFeatures := flag.Bool("excludecellfeatures", false, "Exclude cell features (only useful when visualizing markers)")
+  classifyCells := flag.Bool("classifycells", false, "Output interior and boundary cells of Features separately, with a cellclass property")
   checkCellCenters := flag.Bool("checkcellcenters", true, "Check cell centers for containment in addition to Covering")
   shouldIndent := flag.Bool("pretty", true, "Output pretty printend GeoJSON")
-  maxCellFeatures := flag.Int("maxcellfeatures", 1000, "Skip features which generate more cells than this")
+  outputCellsCsv := flag.Bool("cellscsv", false, "Output cells.csv with one row per Feature cell")
+  checkPolygons := flag.Bool("checkpolygons", false, "Validate Feature polygons and output repairs and remaining problems into polygon_problems.csv")
+  computeMetrics := flag.Bool("metrics", false, "Add covering quality metrics to Features and output them into metrics.csv")
+  findOverlaps := flag.Bool("overlaps", false, "Find Features with overlapping coverings, output into overlaps.csv and overlaps.geojson")
+  gapRegionFilePath := flag.String("gapregion", "", "Find areas of the Features of a GeoJSON file not covered by any input Feature, output into gaps.geojson")
+  gapLevel := flag.Int("gaplevel", 15, "Level of cells the gap region is divided into")
+  maxGapCells := flag.Int("maxgapcells", 100000, "Fail if the gap region would be divided into more cells than this")
+  findInteriorOverlaps := flag.Bool("interioroverlaps", false, "Use interior coverings when finding overlaps, ignoring cells on Feature edges")
+  outputRangesCsv := flag.Bool("rangescsv", false, "Output ranges.csv with the leaf cell id ranges of each Feature covering")
+  sqliteFilePath := flag.String("sqlite", "", "Output Features, covering cell id ranges and markers into a SQLite database file")
+  maxCellFeatures := flag.Int("maxcellfeatures", 1000, "Skip features which generate more cells than this (not used with partitionlevel)")
   maxLevel := flag.Int("maxlevel", 20, "MaxLevel setting for RegionCoverer")
   minLevel := flag.Int("minlevel", 5, "MinLevel setting for RegionCoverer")
   maxCells := flag.Int("maxcells", 1000, "MaxCells setting for RegionCoverer")
-  gridLevel := flag.Int("grid", 0, "Add a grid of given level cells")
+  fractionLevel := flag.Int("fractionlevel", 0, "Cover Features with cells of this level having more than minfraction of their area within the Feature, instead of using RegionCoverer")
+  minFraction := flag.Float64("minfraction", 0.5, "Fraction of cell area that must be within the Feature (only used with fractionlevel)")
+  assembleRelations := flag.Bool("assemblerelations", false, "Assemble the outer and inner member ways of each relation into a single MultiPolygon Feature")
+  subtractHoles := flag.Bool("subtractholes", false, "Exclude holes and inner relation members from the coverings of the Features they are within, instead of covering them separately")
+  partitionLevel := flag.Int("partitionlevel", 0, "Assign each cell of this level to only one Feature, so that coverings do not overlap")
+  partitionPriority := flag.String("partitionpriority", "", "Numeric Feature property deciding which Feature gets a shared cell, before the area within each Feature (only used with partitionlevel)")
+  gridLevelList := flag.String("grid", "", "Add a grid of given level cells. Several comma separated levels add one grid per level, level 0 adds no grid")
+  gridCells := flag.Bool("gridcells", false, "Output grid cells as separate Features with cell ids")
+  gridFromCovering := flag.Bool("gridcovering", false, "Limit grid to cells intersecting the Feature coverings instead of their bounding rectangle")
+  maxGridCells := flag.Int("maxgridcells", 100000, "Fail if the grid would contain more cells than this")
+  gridBbox := flag.String("gridbbox", "", "Generate grid within bounding box. Format: <minlat>,<minlng>,<maxlat>,<maxlng>")
+  gridCenter := flag.String("gridcenter", "", "Generate grid within radius of a point. Format: <latitude>,<longitude>,<radius in meters>")
+  gridRegionFilePath := flag.String("gridregion", "", "Generate grid within the Features of a GeoJSON file")
   outputDirectory := flag.String("outdir", "output", "Output directory")
   markerInputFilePath := flag.String("markers", "", "CSV of markers. Format: <name>,<latitude>,<longitude> Names containing a comma must be in quotes.")
+  cellInputFilePath := flag.String("cells", "", "File of cell id tokens or numeric ids to output, one per line. A .csv file must have a header row, with the cell in the first column and the rest used as properties")
+  normalizeInputCells := flag.Bool("normalizecells", false, "Normalize input cells into a CellUnion, merging duplicate, contained and sibling cells")
+  checkInputCells := flag.Bool("checkcells", false, "Add the Features and markers intersecting each input cell to its properties")
   featureColor := flag.String("cf", "#7e7e7e", "Feature color")
   coverColor := flag.String("cc", "#008000", "Cover cells color")
   holeColor := flag.String("ch", "#ff8080", "Hole cells color")
+  boundaryColor := flag.String("cb", "#ffa500", "Boundary cells color (only used with classifycells)")
   markerColor := flag.String("cm", "#7e7e7e", "Marker color")
   markerCoverColor := flag.String("cmc", "#008000", "Marker cover color")
   markerHoleColor := flag.String("cmh", "#ff8080", "Marker hole color (only used in separate output)")
+  inputCellColor := flag.String("ci", "#0000ff", "Input cells color")
+  overlapColor := flag.String("co", "#ff0000", "Overlap cells color")
+  gapColor := flag.String("cgap", "#ff00ff", "Gap cells color")
+  densifyPoints := flag.Int("densify", 0, "Add this many interpolated points along each cell edge")
+  densifyMaxError := flag.Float64("densifyerror", 0, "Add points along cell edges until they deviate less than this many meters from the true edge")
+  gridColorList := flag.String("cg", "#555555,#3388ff,#ff7800,#9c27b0", "Grid colors, comma separated, one per grid level")
   flag.Parse()
+  gridLevels, err := parseGridLevels(*gridLevelList)
+  check(err)
+  gridColors := strings.Split(*gridColorList, ",")
+  densify := EdgeDensify{points: *densifyPoints, maxError: *densifyMaxError}
   fmt.Println("Separate:", *outputSeparateFiles)
   fmt.Println("Pretty:", *shouldIndent)
   fmt.Println("Skip markerless:", *skipMarkerlessFeatures)
   fmt.Println("Skip featureless:", *skipFeaturelessMarkers)
   fmt.Println("Exclude cell features:", *excludeCellFeatures)
+  fmt.Println("Classify cells:", *classifyCells)
   fmt.Println("Check cell centers:", *checkCellCenters)
-  if *gridLevel > 0 {
-    fmt.Println("Grid:", fmt.Sprintf("Level %d", *gridLevel))
+  fmt.Println("Cells CSV:", *outputCellsCsv)
+  fmt.Println("Check polygons:", *checkPolygons)
+  fmt.Println("Metrics:", *computeMetrics)
+  fmt.Println("Overlaps:", *findOverlaps)
+  fmt.Println("Interior overlaps:", *findInteriorOverlaps)
+  fmt.Println("Gap region:", *gapRegionFilePath)
+  fmt.Println("Gap level:", *gapLevel)
+  fmt.Println("Max gap cells:", *maxGapCells)
+  fmt.Println("Ranges CSV:", *outputRangesCsv)
+  fmt.Println("SQLite:", *sqliteFilePath)
+  if len(gridLevels) > 0 {
+    fmt.Println("Grid:", fmt.Sprintf("Levels %v", gridLevels))
   } else {
     fmt.Println("Grid: false")
   }
+  fmt.Println("Grid cells:", *gridCells)
+  fmt.Println("Grid from covering:", *gridFromCovering)
+  fmt.Println("Max grid cells:", *maxGridCells)
+  fmt.Println("Grid bbox:", *gridBbox)
+  fmt.Println("Grid center:", *gridCenter)
+  fmt.Println("Grid region:", *gridRegionFilePath)
+  fmt.Println("Densify points:", *densifyPoints)
+  fmt.Println("Densify max error:", *densifyMaxError)
   fmt.Println("Max cell features:", *maxCellFeatures)
   fmt.Println("Max level:", *maxLevel)
   fmt.Println("Min level:", *minLevel)
   fmt.Println("Max cells:", *maxCells)
+  if *fractionLevel > 0 {
+    fmt.Println("Fraction covering:", fmt.Sprintf("Level %d, over %v", *fractionLevel, *minFraction))
+  } else {
+    fmt.Println("Fraction covering: false")
+  }
+  if *partitionLevel > 0 {
+    fmt.Println("Partition:", fmt.Sprintf("Level %d", *partitionLevel))
+  } else {
+    fmt.Println("Partition: false")
+  }
+  fmt.Println("Partition priority:", *partitionPriority)
+  fmt.Println("Assemble relations:", *assembleRelations)
+  fmt.Println("Subtract holes:", *subtractHoles)
   fmt.Println("Markers:", *markerInputFilePath != "")
+  fmt.Println("Cells:", *cellInputFilePath != "")
+  fmt.Println("Normalize cells:", *normalizeInputCells)
+  fmt.Println("Check cells:", *checkInputCells)
   fmt.Println("")
   // markerInputFileName := filepath.Base(*markerInputFilePath)
   os.MkdirAll(*outputDirectory, os.ModePerm)
@@ -67,14 +217,37 @@ func main() {
   // Spaghetti for now
 
   boundingRect := s2.EmptyRect()
+  gridCovering := s2.CellUnion{}
+  explicitGridRegions, err := getExplicitGridRegions(*gridBbox, *gridCenter, *gridRegionFilePath)
+  check(err)
+  if *fractionLevel > 0 && *partitionLevel > 0 {
+    check(fmt.Errorf("fractionlevel and partitionlevel can not be used together"))
+  }
 
   var markers []Marker
   featuresWithMarkers := []*geojson.Feature{}
+  cellRows := [][]string{}
+  rangeRows := [][]string{}
+  overlapCandidates := []FeatureCovering{}
+  metricsRows := [][]string{}
+  polygonProblemRows := [][]string{}
+  metricsSummary := CoveringMetrics{cellsByLevel: map[int]int{}}
+  featuresCovering := s2.CellUnion{}
+  var sqliteDb *sql.DB
+  var sqliteTx *sql.Tx
+  if *sqliteFilePath != "" {
+    sqliteDb, sqliteTx = createSqliteDatabase(*sqliteFilePath)
+  }
   if *markerInputFilePath != "" {
-    markers = getMarkersFromCsv(*markerInputFilePath, *markerColor, *gridLevel, *maxLevel)
+    markers = getMarkersFromCsv(*markerInputFilePath, *markerColor, gridLevels, *maxLevel)
   } else {
     markers = []Marker{}
   }
+  inputCells := []InputCell{}
+  if *cellInputFilePath != "" {
+    inputCells, err = getInputCellsFromFile(*cellInputFilePath, *normalizeInputCells, *inputCellColor, *checkInputCells, densify)
+    check(err)
+  }
 
   var featureCollection geojson.FeatureCollection
   inputFileName := ""
@@ -82,68 +255,131 @@ func main() {
     inputFilePath := flag.Args()[0]
     inputFileName = filepath.Base(inputFilePath)
     featureCollection = *getFeatureCollectionFromGeojson(inputFilePath)
+    if *assembleRelations {
+      featureCollection = *getAssembledFeatureCollection(&featureCollection)
+    }
   } else {
     featureCollection = *geojson.NewFeatureCollection()
   }
+  var partition Partition
+  if *partitionLevel > 0 {
+    partition, err = getPartition(featureCollection.Features, *partitionLevel, *partitionPriority, *maxGridCells)
+    check(err)
+    fmt.Println("Partition conflicts:", len(partition.conflictRows))
+    outputRowsToCsv([]string{"cellid", "assignedto", "candidates"}, partition.conflictRows, fmt.Sprintf("%s/partition_conflicts.csv", *outputDirectory))
+  }
+  var relationInnerLoops map[int][]*s2.Loop
+  if *subtractHoles {
+    relationInnerLoops = getRelationInnerLoops(featureCollection.Features)
+  }
   for index, feature := range featureCollection.Features {
-    featureName := ""
-    if feature.Properties["name"] != nil {
-      featureName = feature.Properties["name"].(string)
-    }
-    relRole := "outer"
-    relName := ""
-    if feature.Properties["@relations"] != nil {
-      relRole = feature.Properties["@relations"].([]interface{})[0].(map[string]interface{})["role"].(string)
-      if feature.Properties["@relations"].([]interface{})[0].(map[string]interface{})["reltags"].(map[string]interface{})["name"] != nil {
-        relName = feature.Properties["@relations"].([]interface{})[0].(map[string]interface{})["reltags"].(map[string]interface{})["name"].(string)
-      }
-    }
-    if featureName != "" && relName != "" {
-      featureName = fmt.Sprintf("%s %s", featureName, relName)
-    } else if relName != "" {
-      featureName = relName
+    if *checkPolygons {
+      rows := getPolygonProblemRows(feature)
+      for _, row := range rows {
+        if row[3] == "invalid" {
+          fmt.Println("Invalid polygon", row[0], row[2] + ":", row[4])
+        }
+      }
+      polygonProblemRows = append(polygonProblemRows, rows...)
+    }
+    var coveredFeature CoveredFeature
+    if *partitionLevel > 0 {
+      coveredFeature = getCoveredFeatureFromCellIds(feature, *partitionLevel, partition.cellIds[index], partition.cellFractions[index], *maxGridCells, densify)
+    } else if *fractionLevel > 0 {
+      coveredFeature, err = getFractionCoveredFeature(feature, *fractionLevel, *minFraction, *maxGridCells, densify)
+      check(err)
+    } else if *subtractHoles {
+      coveredFeature = getHoleSubtractedCoveredFeature(feature, getOuterRelationInnerLoops(feature, relationInnerLoops), *maxLevel, *minLevel, *maxCells, densify)
     } else {
-      featureName = "unnamed"
+      coveredFeature = getCoveredFeature(feature, *maxLevel, *minLevel, *maxCells, densify)
     }
-    polygons := []*s2.Polygon{}
-    holePolygons := []*s2.Polygon{}
-    if feature.Geometry.IsPolygon() {
-      outerPolygon, holePolygon := getS2PolygonFromGeojsonPolygon(feature.Geometry.Polygon)
-      polygons = append(polygons, outerPolygon)
-      holePolygons = append(holePolygons, holePolygon)
+    featureName, isHole := coveredFeature.name, coveredFeature.isHole
+    polygons, holePolygons := coveredFeature.polygons, coveredFeature.holePolygons
+    covering, cellIds, cellGeometry := coveredFeature.covering, coveredFeature.cellIds, coveredFeature.cellGeometry
+    holeCovering, holeCellIds, holeCellGeometry := coveredFeature.holeCovering, coveredFeature.holeCellIds, coveredFeature.holeCellGeometry
+
+    featureGridRegions := explicitGridRegions
+    if len(gridLevels) > 0 && explicitGridRegions == nil {
+      if *gridFromCovering {
+        featureGridRegions = []s2.Region{covering}
+        gridCovering = s2.CellUnionFromUnion(gridCovering, *covering)
+      } else {
+        featureBoundingRect := covering.RectBound()
+        featureGridRegions = []s2.Region{featureBoundingRect}
+        boundingRect = boundingRect.Union(featureBoundingRect)
+      }
     }
-    if feature.Geometry.IsLineString() {
-      polygons = append(polygons, getS2PolygonFromGeojsonLineString(feature.Geometry.LineString))
+
+    // Skipping a partitioned Feature would leave the cells assigned to it uncovered
+    if *partitionLevel == 0 && (len(cellIds) > *maxCellFeatures || len(holeCellIds) > *maxCellFeatures) {
+      fmt.Println("Skipping", getPathForFeature(feature), len(cellIds), len(holeCellIds))
+      continue
     }
-    if feature.Geometry.IsMultiPolygon() {
-      for _, polygon := range feature.Geometry.MultiPolygon {
-        outerPolygon, holePolygon := getS2PolygonFromGeojsonPolygon(polygon)
-        polygons = append(polygons, outerPolygon)
-        holePolygons = append(holePolygons, holePolygon)
+
+    if *checkInputCells {
+      checkInputCellsIntersectingCovering(inputCells, covering, isHole, feature)
+    }
+
+    if (*findOverlaps || *findInteriorOverlaps) && ! isHole {
+      overlapCovering := *covering
+      if *findInteriorOverlaps {
+        interiorCovering, _, _ := getCoveringFromPolygons(polygons, true, *maxLevel, *minLevel, *maxCells, densify)
+        overlapCovering = *interiorCovering
       }
+      overlapCovering = s2.CellUnionFromDifference(overlapCovering, *holeCovering)
+      overlapCandidates = append(overlapCandidates, FeatureCovering{path: getPathForFeature(feature), covering: overlapCovering})
     }
-    isHole := relRole == "inner"
-    covering, cellIds, cellGeometry := getCoveringFromPolygons(polygons, isHole, *maxLevel, *minLevel, *maxCells)
-    holeCovering, holeCellIds, holeCellGeometry := getCoveringFromPolygons(holePolygons, true, *maxLevel, *minLevel, *maxCells)
 
-    var featureBoundingRect s2.Rect
-    if *gridLevel > 0 {
-      featureBoundingRect = covering.RectBound()
-      boundingRect = boundingRect.Union(featureBoundingRect)
+    // Holes are subtracted per Feature, as another Feature may fill them
+    if *gapRegionFilePath != "" && ! isHole {
+      featuresCovering = s2.CellUnionFromUnion(featuresCovering, s2.CellUnionFromDifference(*covering, *holeCovering))
     }
 
-    if len(cellIds) > *maxCellFeatures || len(holeCellIds) > *maxCellFeatures {
-      fmt.Println("Skipping", getPathForFeature(feature), len(cellIds), len(holeCellIds))
-      continue
+    if *outputCellsCsv {
+      cellRows = append(cellRows, getCellRows(getPathForFeature(feature), featureName, cellIds, isHole)...)
+      cellRows = append(cellRows, getCellRows(getPathForFeature(feature), featureName, holeCellIds, true)...)
+    }
+    if *outputRangesCsv || *sqliteFilePath != "" {
+      ranges := getCellRangesFromCellUnion(*covering)
+      holeRanges := getCellRangesFromCellUnion(*holeCovering)
+      if *outputRangesCsv {
+        rangeRows = append(rangeRows, getCellRangeRows(getPathForFeature(feature), featureName, ranges, isHole)...)
+        rangeRows = append(rangeRows, getCellRangeRows(getPathForFeature(feature), featureName, holeRanges, true)...)
+      }
+      if *sqliteFilePath != "" {
+        insertSqliteFeature(sqliteTx, getPathForFeature(feature), featureName, feature.Properties, ranges, holeRanges, isHole)
+      }
+    }
+
+    if *computeMetrics {
+      metrics := getCoveringMetrics(coveredFeature, *maxLevel, *minLevel, *maxCells)
+      setCoveringMetricsProperties(feature, metrics)
+      metricsRows = append(metricsRows, getCoveringMetricsRow(getPathForFeature(feature), featureName, metrics))
+      metricsSummary = addCoveringMetrics(metricsSummary, metrics)
     }
 
     feature.SetProperty("stroke", *featureColor)
     feature.SetProperty("fill", *featureColor)
 
-    var cellFeature *geojson.Feature
-    if len(cellIds) > 0 {
-      cellFeature = geojson.NewMultiPolygonFeature(cellGeometry...)
+    cellFeatures := []*geojson.Feature{}
+    if len(cellIds) > 0 && *classifyCells && ! isHole {
+      classCellIds, classCellFractions := getClassifiedCellIds(cellIds, coveredFeature.cellFractions, polygons, holePolygons)
+      classColors := map[string]string{"interior": *coverColor, "boundary": *boundaryColor, "hole": *holeColor}
+      for _, cellClass := range []string{"interior", "boundary", "hole"} {
+        if len(classCellIds[cellClass]) > 0 {
+          cellFeature := getClassifiedCellFeature(classCellIds[cellClass], cellClass, classColors[cellClass], densify)
+          if len(classCellFractions[cellClass]) > 0 {
+            cellFeature.SetProperty("cellfractions", classCellFractions[cellClass])
+          }
+          cellFeatures = append(cellFeatures, cellFeature)
+        }
+      }
+    } else if len(cellIds) > 0 {
+      cellFeature := geojson.NewMultiPolygonFeature(cellGeometry...)
       cellFeature.SetProperty("cellids", cellIds)
+      if len(coveredFeature.cellFractions) > 0 {
+        cellFeature.SetProperty("cellfractions", coveredFeature.cellFractions)
+      }
       cellFeature.SetProperty("stroke-width", 1)
       cellFeature.SetProperty("fill-opacity", 0.3)
       if isHole {
@@ -153,6 +389,10 @@ func main() {
         cellFeature.SetProperty("stroke", *coverColor)
         cellFeature.SetProperty("fill", *coverColor)
       }
+      if *classifyCells {
+        cellFeature.SetProperty("cellclass", "hole")
+      }
+      cellFeatures = append(cellFeatures, cellFeature)
     }
 
     var holeCellFeature *geojson.Feature
@@ -163,12 +403,17 @@ func main() {
       holeCellFeature.SetProperty("stroke-width", 1)
       holeCellFeature.SetProperty("fill", *holeColor)
       holeCellFeature.SetProperty("fill-opacity", 0.3)
+      if *classifyCells {
+        holeCellFeature.SetProperty("cellclass", "hole")
+      }
     }
 
     containedMarkers, containedHoleMarkers, nearbyMarkers := checkContainedMarkerFeatures(covering, holeCovering, *maxLevel, isHole, feature, markers)
+    addRelationHoleLabels(feature, containedMarkers, "within")
 
     if *checkCellCenters {
       containedMarkers, nearbyMarkers = checkContainedCellCenters(polygons, isHole, feature, containedMarkers, nearbyMarkers)
+      addRelationHoleLabels(feature, containedMarkers, "centerwithin")
       containedHoleMarkers, nearbyMarkers = checkContainedCellCenters(holePolygons, true, feature, containedHoleMarkers, nearbyMarkers)
     }
 
@@ -176,14 +421,17 @@ func main() {
       var outputGeojsonData []byte
       var err error
       tempFeatureCollection := geojson.NewFeatureCollection()
-      if *gridLevel > 0 {
-        gridFeature := getGridFeatureFromRect(featureBoundingRect, *gridLevel)
-        gridFeature.SetProperty("stroke-width", 1)
-        gridFeature.SetProperty("fill-opacity", 0.2)
-        tempFeatureCollection.AddFeature(gridFeature)
+      if len(gridLevels) > 0 {
+        gridFeatures, err := getGridFeaturesFromRegions(featureGridRegions, gridLevels, gridColors, *gridCells, *maxGridCells, densify)
+        check(err)
+        for _, gridFeature := range gridFeatures {
+          tempFeatureCollection.AddFeature(gridFeature)
+        }
       }
-      if len(cellIds) > 0 && ! *excludeCellFeatures {
-        tempFeatureCollection.AddFeature(cellFeature)
+      if ! *excludeCellFeatures {
+        for _, cellFeature := range cellFeatures {
+          tempFeatureCollection.AddFeature(cellFeature)
+        }
       }
       if len(holeCellIds) > 0 && ! *excludeCellFeatures {
         tempFeatureCollection.AddFeature(holeCellFeature)
@@ -212,13 +460,18 @@ func main() {
       err = ioutil.WriteFile(fmt.Sprintf("%s/%s_%05d %s.geojson", *outputDirectory, strings.Replace(getPathForFeature(feature), "/", "_", -1), index + 1, featureName), outputGeojsonData, 0644)
       check(err)
     } else {
-      if len(cellIds) > 0 && ! *excludeCellFeatures {
-        featureCollection.AddFeature(cellFeature)
-        if len(containedMarkers) > 0 || len(containedHoleMarkers) > 0 {
-          featuresWithMarkers = append(featuresWithMarkers, cellFeature)
+      if ! *excludeCellFeatures {
+        for _, cellFeature := range cellFeatures {
+          featureCollection.AddFeature(cellFeature)
+          if len(containedMarkers) > 0 || len(containedHoleMarkers) > 0 {
+            featuresWithMarkers = append(featuresWithMarkers, cellFeature)
+          }
         }
       }
       if len(holeCellIds) > 0 && ! *excludeCellFeatures {
+        if *classifyCells {
+          featureCollection.AddFeature(holeCellFeature)
+        }
         if len(containedMarkers) > 0 || len(containedHoleMarkers) > 0 {
           featuresWithMarkers = append(featuresWithMarkers, holeCellFeature)
         }
@@ -234,6 +487,18 @@ func main() {
     }
   }
 
+  if *checkInputCells {
+    checkInputCellsContainingMarkers(inputCells, markers)
+  }
+
+  if *outputSeparateFiles && len(inputCells) > 0 {
+    cellFeatureCollection := geojson.NewFeatureCollection()
+    for _, inputCell := range inputCells {
+      cellFeatureCollection.AddFeature(inputCell.feature)
+    }
+    writeGeojsonFile(cellFeatureCollection, fmt.Sprintf("%s/cells.geojson", *outputDirectory), *shouldIndent)
+  }
+
   if ! *outputSeparateFiles {
     var outputGeojsonData []byte
     var err error
@@ -248,17 +513,28 @@ func main() {
       }
       featureCollection.AddFeature(marker.feature)
     }
-    if *gridLevel > 0 {
+    for _, inputCell := range inputCells {
+      featureCollection.AddFeature(inputCell.feature)
+    }
+    if len(gridLevels) > 0 {
       cellIds := []s2.CellID{}
       for _, marker := range markers {
         cellIds = append(cellIds, *marker.cellId)
       }
       markersCellUnion := s2.CellUnion(cellIds)
-      boundingRect = boundingRect.Union(markersCellUnion.RectBound())
-      gridFeature := getGridFeatureFromRect(boundingRect, *gridLevel)
-      gridFeature.SetProperty("stroke-width", 1)
-      gridFeature.SetProperty("fill-opacity", 0.2)
-      featureCollection.Features = append([]*geojson.Feature{gridFeature}, featureCollection.Features...)
+      gridRegions := explicitGridRegions
+      if gridRegions == nil {
+        if *gridFromCovering {
+          gridCovering = s2.CellUnionFromUnion(gridCovering, markersCellUnion)
+          gridRegions = []s2.Region{&gridCovering}
+        } else {
+          boundingRect = boundingRect.Union(markersCellUnion.RectBound())
+          gridRegions = []s2.Region{boundingRect}
+        }
+      }
+      gridFeatures, err := getGridFeaturesFromRegions(gridRegions, gridLevels, gridColors, *gridCells, *maxGridCells, densify)
+      check(err)
+      featureCollection.Features = append(gridFeatures, featureCollection.Features...)
     }
     if *shouldIndent {
       outputGeojsonData, err = json.MarshalIndent(featureCollection, "", " ")
@@ -279,6 +555,54 @@ func main() {
     outputContainedMarkersToCsv(markers, *outputDirectory)
   }
 
+  if *outputCellsCsv {
+    outputRowsToCsv([]string{"path", "name", "cellid", "numericid", "level", "ishole"}, cellRows, fmt.Sprintf("%s/cells.csv", *outputDirectory))
+  }
+
+  if *outputRangesCsv {
+    outputRowsToCsv([]string{"path", "name", "rangemin", "rangemax", "rangeminsigned", "rangemaxsigned", "ishole"}, rangeRows, fmt.Sprintf("%s/ranges.csv", *outputDirectory))
+  }
+
+  if *checkPolygons {
+    outputRowsToCsv(polygonProblemsCsvHeader, polygonProblemRows, fmt.Sprintf("%s/polygon_problems.csv", *outputDirectory))
+    invalidCount := 0
+    for _, row := range polygonProblemRows {
+      if row[3] == "invalid" {
+        invalidCount++
+      }
+    }
+    fmt.Println("Polygon problems:", len(polygonProblemRows) - invalidCount, "repaired,", invalidCount, "invalid")
+  }
+
+  if *computeMetrics {
+    outputRowsToCsv(coveringMetricsCsvHeader, metricsRows, fmt.Sprintf("%s/metrics.csv", *outputDirectory))
+    printCoveringMetricsSummary(metricsSummary, len(metricsRows))
+  }
+
+  if *gapRegionFilePath != "" {
+    regionCells, gapCells, gapFractions, err := getCoverageGaps(getRegionsFromGeojson(*gapRegionFilePath), *gapLevel, *maxGapCells, featuresCovering)
+    check(err)
+    regionArea, gapArea := getCellUnionAreaMeters(regionCells), getGapAreaMeters(gapCells, gapFractions)
+    fmt.Println("Gap region:", fmt.Sprintf("%d cells, %.0f m²", len(regionCells), regionArea))
+    fmt.Println("Gaps:", fmt.Sprintf("%d cells, %.0f m²", len(gapCells), gapArea))
+    if regionArea > 0 {
+      fmt.Println("Gap percentage:", fmt.Sprintf("%.2f%%", 100 * gapArea / regionArea))
+    }
+    writeGeojsonFile(getCoverageGapsFeatureCollection(gapCells, gapFractions, regionArea, gapArea, *gapColor, densify), fmt.Sprintf("%s/gaps.geojson", *outputDirectory), *shouldIndent)
+  }
+
+  if *findOverlaps || *findInteriorOverlaps {
+    overlaps := getFeatureOverlaps(overlapCandidates)
+    fmt.Println("Overlapping Feature pairs:", len(overlaps))
+    outputRowsToCsv([]string{"path_a", "path_b", "sharedcells", "sharedarea"}, getFeatureOverlapRows(overlaps), fmt.Sprintf("%s/overlaps.csv", *outputDirectory))
+    writeGeojsonFile(getFeatureOverlapsFeatureCollection(overlaps, *overlapColor, densify), fmt.Sprintf("%s/overlaps.geojson", *outputDirectory), *shouldIndent)
+  }
+
+  if *sqliteFilePath != "" {
+    insertSqliteMarkers(sqliteTx, markers)
+    closeSqliteDatabase(sqliteDb, sqliteTx)
+  }
+
   // End
   fmt.Println("Done")
 }
@@ -293,39 +617,309 @@ func getFeatureCollectionFromGeojson(geojsonFilename string) *geojson.FeatureCol
 }
 
 
-func getMarkersFromCsv(csvFilename string, markerColor string, gridLevel int, maxLevel int) []Marker {
+func getCoveredFeature(feature *geojson.Feature, maxLevel int, minLevel int, maxCells int, densify EdgeDensify) CoveredFeature {
+  polygons, holePolygons := getS2PolygonsFromFeature(feature)
+  return getCoveredFeatureFromPolygons(feature, polygons, holePolygons, maxLevel, minLevel, maxCells, densify)
+}
+
+
+// Holes of the Feature and the inner Features of its relation are made part of its polygons,
+// so that the covering excludes them and no separate hole covering is needed.
+// Inner Features themselves get no covering.
+func getHoleSubtractedCoveredFeature(feature *geojson.Feature, relationInnerLoops []*s2.Loop, maxLevel int, minLevel int, maxCells int, densify EdgeDensify) CoveredFeature {
+  polygons := []*s2.Polygon{}
+  if getRoleForFeature(feature) != "inner" {
+    polygons = getS2PolygonsWithHolesFromFeature(feature, relationInnerLoops)
+  }
+  return getCoveredFeatureFromPolygons(feature, polygons, []*s2.Polygon{}, maxLevel, minLevel, maxCells, densify)
+}
+
+
+func getCoveredFeatureFromPolygons(feature *geojson.Feature, polygons []*s2.Polygon, holePolygons []*s2.Polygon, maxLevel int, minLevel int, maxCells int, densify EdgeDensify) CoveredFeature {
+  var coveredFeature CoveredFeature
+  coveredFeature.feature = feature
+  coveredFeature.name = getNameForFeature(feature)
+  coveredFeature.isHole = getRoleForFeature(feature) == "inner"
+  coveredFeature.polygons, coveredFeature.holePolygons = polygons, holePolygons
+  polygonCoverings, coveringDuration := getPolygonCoverings(coveredFeature.polygons, coveredFeature.isHole, maxLevel, minLevel, maxCells)
+  holePolygonCoverings, holeCoveringDuration := getPolygonCoverings(coveredFeature.holePolygons, true, maxLevel, minLevel, maxCells)
+  coveredFeature.covering, coveredFeature.cellIds, coveredFeature.cellGeometry = getCoveringFromPolygonCoverings(polygonCoverings, densify)
+  coveredFeature.holeCovering, coveredFeature.holeCellIds, coveredFeature.holeCellGeometry = getCoveringFromPolygonCoverings(holePolygonCoverings, densify)
+  coveredFeature.coveringDuration = coveringDuration + holeCoveringDuration
+  return coveredFeature
+}
+
+
+// The name of the Feature followed by the names of all its relations.
+func getNameForFeature(feature *geojson.Feature) string {
+  featureName := ""
+  if name, ok := feature.Properties["name"].(string); ok {
+    featureName = name
+  }
+  relNames := []string{}
+  for _, membership := range getRelationMembershipsForFeature(feature) {
+    if membership.name != "" && ! containsString(relNames, membership.name) {
+      relNames = append(relNames, membership.name)
+    }
+  }
+  relName := strings.Join(relNames, ", ")
+  if featureName != "" && relName != "" {
+    featureName = fmt.Sprintf("%s %s", featureName, relName)
+  } else if relName != "" {
+    featureName = relName
+  } else if featureName == "" {
+    featureName = "unnamed"
+  }
+  return featureName
+}
+
+
+// A Feature is inner only when it is an inner member of every relation it belongs to.
+// A way that is outer in one relation and inner in another is covered as an area,
+// and is a hole only of the relations it is inner in.
+func getRoleForFeature(feature *geojson.Feature) string {
+  memberships := getRelationMembershipsForFeature(feature)
+  if len(memberships) == 0 {
+    return "outer"
+  }
+  for _, membership := range memberships {
+    if membership.role != "inner" {
+      return "outer"
+    }
+  }
+  return "inner"
+}
+
+
+func getRelationMembershipsForFeature(feature *geojson.Feature) []RelationMembership {
+  memberships := []RelationMembership{}
+  relations, ok := feature.Properties["@relations"].([]interface{})
+  if ! ok {
+    return memberships
+  }
+  // Malformed memberships are skipped here, getFeatureInputError reports them
+  for _, relation := range relations {
+    relationProperties, ok := relation.(map[string]interface{})
+    if ! ok {
+      continue
+    }
+    id, ok := relationProperties["rel"].(float64)
+    if ! ok {
+      continue
+    }
+    membership := RelationMembership{id: int(id), role: "outer"}
+    if role, ok := relationProperties["role"].(string); ok && role != "" {
+      membership.role = role
+    }
+    if relTags, ok := relationProperties["reltags"].(map[string]interface{}); ok {
+      membership.tags = relTags
+      if name, ok := relTags["name"].(string); ok {
+        membership.name = name
+      }
+    }
+    memberships = append(memberships, membership)
+  }
+  return memberships
+}
+
+
+// Describes the first problem that would make covering the Feature fail, or returns nil.
+func getFeatureInputError(feature *geojson.Feature) error {
+  if relations := feature.Properties["@relations"]; relations != nil {
+    relationList, ok := relations.([]interface{})
+    if ! ok {
+      return fmt.Errorf("@relations of Feature %q is not a list", getIdTextForFeature(feature))
+    }
+    for index, relation := range relationList {
+      relationProperties, ok := relation.(map[string]interface{})
+      if ! ok {
+        return fmt.Errorf("@relations[%d] of Feature %q is not an object", index, getIdTextForFeature(feature))
+      }
+      if _, ok := relationProperties["rel"].(float64); ! ok {
+        return fmt.Errorf("@relations[%d] of Feature %q has no numeric rel", index, getIdTextForFeature(feature))
+      }
+    }
+  }
+  if feature.Geometry == nil {
+    return fmt.Errorf("Feature %q has no geometry", getIdTextForFeature(feature))
+  }
+  rings := [][][]float64{}
+  if feature.Geometry.IsLineString() {
+    rings = append(rings, feature.Geometry.LineString)
+  }
+  if feature.Geometry.IsPolygon() {
+    rings = append(rings, feature.Geometry.Polygon...)
+  }
+  if feature.Geometry.IsMultiPolygon() {
+    for _, polygon := range feature.Geometry.MultiPolygon {
+      rings = append(rings, polygon...)
+    }
+  }
+  for _, ring := range rings {
+    if len(ring) == 0 {
+      return fmt.Errorf("Feature %q has an empty ring", getIdTextForFeature(feature))
+    }
+    for _, position := range ring {
+      if len(position) < 2 {
+        return fmt.Errorf("Feature %q has a position without both longitude and latitude", getIdTextForFeature(feature))
+      }
+    }
+  }
+  return nil
+}
+
+
+func containsString(values []string, value string) bool {
+  for _, existing := range values {
+    if existing == value {
+      return true
+    }
+  }
+  return false
+}
+
+
+func getMarkersFromCsv(csvFilename string, markerColor string, gridLevels []int, maxLevel int) []Marker {
   markers := []Marker{}
   for _, row := range readCsv(csvFilename) {
-    var marker Marker
     name := row[0]
     lat, err := strconv.ParseFloat(row[1], 64)
     check(err)
     lng, err := strconv.ParseFloat(row[2], 64)
     check(err)
-    latlng := s2.LatLngFromDegrees(lat, lng)
-    cellId := s2.CellIDFromLatLng(latlng)
-    cellAtLevel := s2.CellFromCellID(cellId.Parent(maxLevel))
-    marker.cellId = &cellId
-    marker.cellAtLevel = &cellAtLevel
-    feature := geojson.NewPointFeature([]float64{lng, lat})
-    if gridLevel > 0 {
-      feature.SetProperty(fmt.Sprintf("level%dcellid", gridLevel), cellId.Parent(gridLevel).ToToken())
-    }
-    if maxLevel > 0 && maxLevel != gridLevel {
-      feature.SetProperty(fmt.Sprintf("level%dcellid", maxLevel), cellId.Parent(maxLevel).ToToken())
-    }
-    feature.SetProperty("name", name)
-    feature.SetProperty("cellid", cellId.ToToken())
-    feature.SetProperty("within", []string{})
-    feature.SetProperty("centerwithin", []string{})
-    feature.SetProperty("marker-color", markerColor)
-    marker.feature = feature
-    markers = append(markers, marker)
+    markers = append(markers, getMarkerFromLatLng(name, lat, lng, markerColor, gridLevels, maxLevel))
   }
   return markers
 }
 
 
+func getMarkerFromLatLng(name string, lat float64, lng float64, markerColor string, gridLevels []int, maxLevel int) Marker {
+  var marker Marker
+  latlng := s2.LatLngFromDegrees(lat, lng)
+  cellId := s2.CellIDFromLatLng(latlng)
+  cellAtLevel := s2.CellFromCellID(cellId.Parent(maxLevel))
+  marker.cellId = &cellId
+  marker.cellAtLevel = &cellAtLevel
+  feature := geojson.NewPointFeature([]float64{lng, lat})
+  for _, gridLevel := range gridLevels {
+    feature.SetProperty(fmt.Sprintf("level%dcellid", gridLevel), cellId.Parent(gridLevel).ToToken())
+  }
+  if maxLevel > 0 && feature.Properties[fmt.Sprintf("level%dcellid", maxLevel)] == nil {
+    feature.SetProperty(fmt.Sprintf("level%dcellid", maxLevel), cellId.Parent(maxLevel).ToToken())
+  }
+  feature.SetProperty("name", name)
+  feature.SetProperty("cellid", cellId.ToToken())
+  feature.SetProperty("within", []string{})
+  feature.SetProperty("centerwithin", []string{})
+  feature.SetProperty("marker-color", markerColor)
+  marker.feature = feature
+  return marker
+}
+
+
+func getInputCellsFromFile(cellFilename string, normalize bool, cellColor string, checkCells bool, densify EdgeDensify) ([]InputCell, error) {
+  cellIds := []s2.CellID{}
+  cellProperties := map[s2.CellID]map[string]string{}
+  if strings.ToLower(filepath.Ext(cellFilename)) == ".csv" {
+    rows := readCsv(cellFilename)
+    if len(rows) == 0 {
+      return nil, fmt.Errorf("%s has no header row", cellFilename)
+    }
+    for _, row := range rows[1:] {
+      cellId, err := parseCellId(row[0], 30)
+      if err != nil {
+        return nil, err
+      }
+      cellIds = append(cellIds, cellId)
+      properties := map[string]string{}
+      for column := 1; column < len(row) && column < len(rows[0]); column++ {
+        properties[rows[0][column]] = row[column]
+      }
+      cellProperties[cellId] = properties
+    }
+  } else {
+    cellData, err := ioutil.ReadFile(cellFilename)
+    if err != nil {
+      return nil, err
+    }
+    for _, line := range strings.Split(string(cellData), "\n") {
+      line = strings.TrimSpace(line)
+      if line == "" || strings.HasPrefix(line, "#") {
+        continue
+      }
+      cellId, err := parseCellId(line, 30)
+      if err != nil {
+        return nil, err
+      }
+      cellIds = append(cellIds, cellId)
+    }
+  }
+  if normalize {
+    cellUnion := s2.CellUnion(cellIds)
+    cellUnion.Normalize()
+    cellIds = cellUnion
+  }
+  inputCells := []InputCell{}
+  for _, cellId := range cellIds {
+    feature := getCellFeatureFromCellId(cellId, densify)
+    // Cells merged by normalizing have no properties of their own
+    for key, value := range cellProperties[cellId] {
+      feature.SetProperty(key, value)
+    }
+    feature.SetProperty("stroke", cellColor)
+    feature.SetProperty("stroke-width", 1)
+    feature.SetProperty("fill", cellColor)
+    feature.SetProperty("fill-opacity", 0.3)
+    if checkCells {
+      feature.SetProperty("features", []string{})
+      feature.SetProperty("markers", []string{})
+    }
+    inputCells = append(inputCells, InputCell{cellId: cellId, feature: feature})
+  }
+  return inputCells, nil
+}
+
+
+func checkInputCellsIntersectingCovering(inputCells []InputCell, coveringCellUnion *s2.CellUnion, isHole bool, coveringFeature *geojson.Feature) {
+  for _, inputCell := range inputCells {
+    if coveringCellUnion.IntersectsCellID(inputCell.cellId) {
+      featureText := getPathForFeature(coveringFeature)
+      if isHole {
+        featureText += " (hole)"
+      }
+      features := append(inputCell.feature.Properties["features"].([]string), featureText)
+      inputCell.feature.SetProperty("features", features)
+    }
+  }
+}
+
+
+func checkInputCellsContainingMarkers(inputCells []InputCell, markers []Marker) {
+  for _, inputCell := range inputCells {
+    for _, marker := range markers {
+      if inputCell.cellId.Contains(*marker.cellId) {
+        markerNames := append(inputCell.feature.Properties["markers"].([]string), marker.feature.Properties["name"].(string))
+        inputCell.feature.SetProperty("markers", markerNames)
+      }
+    }
+  }
+}
+
+
+func writeGeojsonFile(featureCollection *geojson.FeatureCollection, geojsonFilename string, shouldIndent bool) {
+  var outputGeojsonData []byte
+  var err error
+  if shouldIndent {
+    outputGeojsonData, err = json.MarshalIndent(featureCollection, "", " ")
+  } else {
+    outputGeojsonData, err = featureCollection.MarshalJSON()
+  }
+  check(err)
+  err = ioutil.WriteFile(geojsonFilename, outputGeojsonData, 0644)
+  check(err)
+}
+
+
 func readCsv(csvFilename string) [][]string {
   csvFile, err := os.Open(csvFilename)
   check(err)
@@ -372,6 +966,71 @@ func outputContainedCellCentersToCsv(markers []Marker, outputDirectory string) {
 }
 
 
+func getCellRows(path string, name string, cellTokens []string, isHole bool) [][]string {
+  rows := [][]string{}
+  for _, cellToken := range cellTokens {
+    cellId := s2.CellIDFromToken(cellToken)
+    rows = append(rows, []string{path, name, cellToken, strconv.FormatUint(uint64(cellId), 10), strconv.Itoa(cellId.Level()), strconv.FormatBool(isHole)})
+  }
+  return rows
+}
+
+
+func outputRowsToCsv(header []string, rows [][]string, csvFilename string) {
+  csvFile, err := os.Create(csvFilename)
+  check(err)
+  defer csvFile.Close()
+  writer := csv.NewWriter(csvFile)
+  defer writer.Flush()
+  err = writer.Write(header)
+  check(err)
+  err = writer.WriteAll(rows)
+  check(err)
+}
+
+
+// Ranges are returned in order, with overlapping and adjacent ranges merged.
+func getCellRangesFromCellUnion(cellUnion s2.CellUnion) []CellRange {
+  ranges := []CellRange{}
+  for _, cellId := range cellUnion {
+    ranges = append(ranges, CellRange{min: cellId.RangeMin(), max: cellId.RangeMax()})
+  }
+  sort.Slice(ranges, func(i, j int) bool { return ranges[i].min < ranges[j].min })
+  mergedRanges := []CellRange{}
+  for _, cellRange := range ranges {
+    last := len(mergedRanges) - 1
+    // Leaf cell ids are not consecutive integers, the next leaf cell is max.Next().
+    // Ranges are not merged across faces 3 and 4, where signed ids change sign.
+    if last >= 0 && cellRange.min <= mergedRanges[last].max.Next() && (int64(cellRange.min) >= 0) == (int64(mergedRanges[last].max) >= 0) {
+      if cellRange.max > mergedRanges[last].max {
+        mergedRanges[last].max = cellRange.max
+      }
+    } else {
+      mergedRanges = append(mergedRanges, cellRange)
+    }
+  }
+  return mergedRanges
+}
+
+
+// Ranges are written both as unsigned and signed 64 bit integers,
+// since databases often lack an unsigned 64 bit type.
+func getCellRangeRows(path string, name string, ranges []CellRange, isHole bool) [][]string {
+  rows := [][]string{}
+  for _, cellRange := range ranges {
+    rows = append(rows, []string{
+      path,
+      name,
+      strconv.FormatUint(uint64(cellRange.min), 10),
+      strconv.FormatUint(uint64(cellRange.max), 10),
+      strconv.FormatInt(int64(cellRange.min), 10),
+      strconv.FormatInt(int64(cellRange.max), 10),
+      strconv.FormatBool(isHole)})
+  }
+  return rows
+}
+
+
 func checkContainedMarkerFeatures(
   coveringCellUnion *s2.CellUnion,
   holeCoveringCellUnion *s2.CellUnion,
@@ -418,6 +1077,26 @@ func checkContainedMarkerFeatures(
 }
 
 
+// A way that is outer in some relations and inner in others is covered as an area, so
+// markers within it are also labeled as within a hole of each relation it is inner in.
+func addRelationHoleLabels(feature *geojson.Feature, markers []Marker, property string) {
+  if getRoleForFeature(feature) == "inner" {
+    return
+  }
+  for _, membership := range getRelationMembershipsForFeature(feature) {
+    if membership.role != "inner" {
+      continue
+    }
+    withinText := fmt.Sprintf("relation/%d/%s (hole)", membership.id, getIdTextForFeature(feature))
+    for _, marker := range markers {
+      within := marker.feature.Properties[property]
+      within = append(within.([]string), withinText)
+      marker.feature.SetProperty(property, within)
+    }
+  }
+}
+
+
 func checkContainedCellCenters(polygons []*s2.Polygon, isHole bool, coveringFeature *geojson.Feature, markers []Marker, nearbyMarkers []Marker) ([]Marker, []Marker) {
   containedMarkers := []Marker{}
   for _, marker := range markers {
@@ -444,27 +1123,222 @@ func checkContainedCellCenters(polygons []*s2.Polygon, isHole bool, coveringFeat
 }
 
 
-func getGridFeatureFromRect(rect s2.Rect, gridLevel int) *geojson.Feature {
-  regionCoverer := &s2.RegionCoverer{MaxLevel: gridLevel, MinLevel: gridLevel, MaxCells: 10}
-  covering := regionCoverer.Covering(rect)
-  _, cellGeometry := getGeojsonMultiPolygonFromCellUnion(covering)
-  feature := geojson.NewMultiPolygonFeature(cellGeometry...)
+// Each level gets its own color, and coarser levels get wider strokes
+// so that nested grids remain distinguishable.
+func getGridFeaturesFromRegions(regions []s2.Region, gridLevels []int, gridColors []string, separateCells bool, maxGridCells int, densify EdgeDensify) ([]*geojson.Feature, error) {
+  features := []*geojson.Feature{}
+  for index, gridLevel := range gridLevels {
+    levelFeatures, err := getGridLevelFeaturesFromRegions(regions, gridLevel, separateCells, maxGridCells, densify)
+    if err != nil {
+      return nil, err
+    }
+    gridColor := gridColors[index % len(gridColors)]
+    for _, feature := range levelFeatures {
+      feature.SetProperty("gridlevel", gridLevel)
+      feature.SetProperty("stroke", gridColor)
+      feature.SetProperty("stroke-width", len(gridLevels) - index)
+      feature.SetProperty("fill", gridColor)
+      feature.SetProperty("fill-opacity", 0.2 / float64(len(gridLevels)))
+    }
+    features = append(features, levelFeatures...)
+  }
+  return features, nil
+}
+
+
+func getGridLevelFeaturesFromRegions(regions []s2.Region, gridLevel int, separateCells bool, maxGridCells int, densify EdgeDensify) ([]*geojson.Feature, error) {
+  covering := s2.CellUnion{}
+  for _, region := range regions {
+    cellIds, err := getGridCellIdsFromRegion(region, gridLevel, maxGridCells)
+    if err != nil {
+      return nil, fmt.Errorf("grid has %v, use a higher grid level or -maxgridcells", err)
+    }
+    covering = s2.CellUnionFromUnion(covering, cellIds)
+  }
+  // The union replaces complete sets of siblings with their parent
+  covering.Denormalize(gridLevel, 1)
+  if len(covering) > maxGridCells {
+    return nil, fmt.Errorf("grid of level %d cells exceeds %d cells, use a higher grid level or -maxgridcells", gridLevel, maxGridCells)
+  }
+  features := []*geojson.Feature{}
+  if separateCells {
+    for _, cellId := range covering {
+      features = append(features, getCellFeatureFromCellId(cellId, densify))
+    }
+  } else {
+    _, cellGeometry := getGeojsonMultiPolygonFromCellUnion(covering, densify)
+    features = append(features, geojson.NewMultiPolygonFeature(cellGeometry...))
+  }
+  return features, nil
+}
+
+
+// Unlike RegionCoverer, which gives up detail to stay within MaxCells,
+// this returns every cell of the given level intersecting the region.
+func getGridCellIdsFromRegion(region s2.Region, gridLevel int, maxGridCells int) (s2.CellUnion, error) {
+  cellIds := s2.CellUnion{}
+  // Candidates are a stack, pushed in reverse so that cells come out in cell id order
+  candidates := []s2.CellID{}
+  for face := 5; face >= 0; face-- {
+    candidates = append(candidates, s2.CellIDFromFace(face))
+  }
+  for len(candidates) > 0 {
+    cellId := candidates[len(candidates) - 1]
+    candidates = candidates[:len(candidates) - 1]
+    if ! region.IntersectsCell(s2.CellFromCellID(cellId)) {
+      continue
+    }
+    if cellId.Level() < gridLevel {
+      children := cellId.Children()
+      for k := len(children) - 1; k >= 0; k-- {
+        candidates = append(candidates, children[k])
+      }
+      continue
+    }
+    if len(cellIds) >= maxGridCells {
+      return nil, fmt.Errorf("more than %d level %d cells", maxGridCells, gridLevel)
+    }
+    cellIds = append(cellIds, cellId)
+  }
+  return cellIds, nil
+}
+
+
+// Only one of bbox, center and region file may be given.
+// Returns nil when the grid should follow the Features and markers instead.
+func getExplicitGridRegions(bbox string, center string, regionFilePath string) ([]s2.Region, error) {
+  given := 0
+  for _, option := range []string{bbox, center, regionFilePath} {
+    if option != "" {
+      given++
+    }
+  }
+  if given > 1 {
+    return nil, fmt.Errorf("only one of -gridbbox, -gridcenter and -gridregion may be used")
+  }
+  if bbox != "" {
+    values, err := parseFloatList(bbox, 4)
+    if err != nil {
+      return nil, fmt.Errorf("invalid -gridbbox: %v", err)
+    }
+    rect := s2.RectFromLatLng(s2.LatLngFromDegrees(values[0], values[1])).AddPoint(s2.LatLngFromDegrees(values[2], values[3]))
+    return []s2.Region{rect}, nil
+  }
+  if center != "" {
+    values, err := parseFloatList(center, 3)
+    if err != nil {
+      return nil, fmt.Errorf("invalid -gridcenter: %v", err)
+    }
+    centerPoint := s2.PointFromLatLng(s2.LatLngFromDegrees(values[0], values[1]))
+    return []s2.Region{s2.CapFromCenterAngle(centerPoint, s1.Angle(values[2] / earthRadiusMeters))}, nil
+  }
+  if regionFilePath != "" {
+    return getRegionsFromGeojson(regionFilePath), nil
+  }
+  return nil, nil
+}
+
+
+// Holes are kept as inner loops, so cells within them are not part of the regions.
+func getRegionsFromGeojson(geojsonFilename string) []s2.Region {
+  regions := []s2.Region{}
+  for _, feature := range getFeatureCollectionFromGeojson(geojsonFilename).Features {
+    for _, polygon := range getS2PolygonsWithHolesFromFeature(feature, []*s2.Loop{}) {
+      regions = append(regions, polygon)
+    }
+  }
+  return regions
+}
+
+
+// Levels are returned sorted from coarsest to finest, without duplicates.
+func parseGridLevels(text string) ([]int, error) {
+  levels := []int{}
+  if text == "" {
+    return levels, nil
+  }
+  for _, part := range strings.Split(text, ",") {
+    gridLevel, err := strconv.Atoi(strings.TrimSpace(part))
+    if err != nil {
+      return nil, fmt.Errorf("invalid grid level %q", part)
+    }
+    if gridLevel < 0 || gridLevel > 30 {
+      return nil, fmt.Errorf("grid level %d is not between 0 and 30", gridLevel)
+    }
+    // Level 0 has always meant no grid
+    if gridLevel == 0 {
+      continue
+    }
+    levels = append(levels, gridLevel)
+  }
+  sort.Ints(levels)
+  gridLevels := []int{}
+  for _, level := range levels {
+    if len(gridLevels) == 0 || gridLevels[len(gridLevels) - 1] != level {
+      gridLevels = append(gridLevels, level)
+    }
+  }
+  return gridLevels, nil
+}
+
+
+func parseFloatList(text string, count int) ([]float64, error) {
+  parts := strings.Split(text, ",")
+  if len(parts) != count {
+    return nil, fmt.Errorf("expected %d comma separated values, got %d", count, len(parts))
+  }
+  values := []float64{}
+  for _, part := range parts {
+    value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+    if err != nil {
+      return nil, err
+    }
+    values = append(values, value)
+  }
+  return values, nil
+}
+
+
+func getCellFeatureFromCellId(cellId s2.CellID, densify EdgeDensify) *geojson.Feature {
+  feature := geojson.NewPolygonFeature(getGeometryFromCellId(cellId, densify))
+  feature.SetProperty("cellid", cellId.ToToken())
+  feature.SetProperty("level", cellId.Level())
+  feature.SetProperty("face", cellId.Face())
+  // Numeric ids are written as strings, since uint64 does not survive JSON parsers using doubles
+  feature.SetProperty("numericid", strconv.FormatUint(uint64(cellId), 10))
   return feature
 }
 
 
-func getCoveringFromPolygons(polygons []*s2.Polygon, isHole bool, maxLevel int, minLevel int, maxCells int) (*s2.CellUnion, []string, [][][][]float64) {
-  var covering s2.CellUnion
-  var cellIds []string
-  var cellGeometry [][][][]float64
+func getCoveringFromPolygons(polygons []*s2.Polygon, isHole bool, maxLevel int, minLevel int, maxCells int, densify EdgeDensify) (*s2.CellUnion, []string, [][][][]float64) {
+  polygonCoverings, _ := getPolygonCoverings(polygons, isHole, maxLevel, minLevel, maxCells)
+  return getCoveringFromPolygonCoverings(polygonCoverings, densify)
+}
+
+
+// Returns a covering per polygon, and the time spent in RegionCoverer.
+func getPolygonCoverings(polygons []*s2.Polygon, isHole bool, maxLevel int, minLevel int, maxCells int) ([]s2.CellUnion, time.Duration) {
+  polygonCoverings := []s2.CellUnion{}
   regionCoverer := &s2.RegionCoverer{MaxLevel: maxLevel, MinLevel: minLevel, MaxCells: maxCells}
+  start := time.Now()
   for _, polygon := range polygons {
     if isHole {
-      covering = regionCoverer.InteriorCellUnion(polygon)
+      polygonCoverings = append(polygonCoverings, regionCoverer.InteriorCellUnion(polygon))
     } else {
-      covering = regionCoverer.Covering(polygon)
+      polygonCoverings = append(polygonCoverings, regionCoverer.Covering(polygon))
     }
-    ci, cg := getGeojsonMultiPolygonFromCellUnion(covering)
+  }
+  return polygonCoverings, time.Since(start)
+}
+
+
+func getCoveringFromPolygonCoverings(polygonCoverings []s2.CellUnion, densify EdgeDensify) (*s2.CellUnion, []string, [][][][]float64) {
+  var covering s2.CellUnion
+  var cellIds []string
+  var cellGeometry [][][][]float64
+  for _, polygonCovering := range polygonCoverings {
+    covering = s2.CellUnionFromUnion(covering, polygonCovering)
+    ci, cg := getGeojsonMultiPolygonFromCellUnion(polygonCovering, densify)
     cellIds = append(cellIds, ci...)
     cellGeometry = append(cellGeometry, cg...)
   }
@@ -472,24 +1346,89 @@ func getCoveringFromPolygons(polygons []*s2.Polygon, isHole bool, maxLevel int,
 }
 
 
-func getGeojsonMultiPolygonFromCellUnion(cellUnion s2.CellUnion) ([]string, [][][][]float64) {
+// A cell is interior if it is fully inside a polygon and does not touch its holes,
+// hole if it is fully inside a hole, otherwise it is on the boundary. The fractions,
+// if any, are grouped along with their cells.
+func getClassifiedCellIds(cellTokens []string, cellFractions []float64, polygons []*s2.Polygon, holePolygons []*s2.Polygon) (map[string]s2.CellUnion, map[string][]float64) {
+  classCellIds := map[string]s2.CellUnion{}
+  classCellFractions := map[string][]float64{}
+  for index, cellToken := range cellTokens {
+    cellId := s2.CellIDFromToken(cellToken)
+    cellClass := getCellClass(s2.CellFromCellID(cellId), polygons, holePolygons)
+    classCellIds[cellClass] = append(classCellIds[cellClass], cellId)
+    if len(cellFractions) > 0 {
+      classCellFractions[cellClass] = append(classCellFractions[cellClass], cellFractions[index])
+    }
+  }
+  return classCellIds, classCellFractions
+}
+
+
+func getCellClass(cell s2.Cell, polygons []*s2.Polygon, holePolygons []*s2.Polygon) string {
+  for _, holePolygon := range holePolygons {
+    if holePolygon.ContainsCell(cell) {
+      return "hole"
+    }
+  }
+  for _, holePolygon := range holePolygons {
+    if holePolygon.IntersectsCell(cell) {
+      return "boundary"
+    }
+  }
+  for _, polygon := range polygons {
+    if polygon.ContainsCell(cell) {
+      return "interior"
+    }
+  }
+  return "boundary"
+}
+
+
+func getClassifiedCellFeature(cellUnion s2.CellUnion, cellClass string, color string, densify EdgeDensify) *geojson.Feature {
+  cellIds, cellGeometry := getGeojsonMultiPolygonFromCellUnion(cellUnion, densify)
+  feature := geojson.NewMultiPolygonFeature(cellGeometry...)
+  feature.SetProperty("cellids", cellIds)
+  feature.SetProperty("cellclass", cellClass)
+  feature.SetProperty("stroke", color)
+  feature.SetProperty("stroke-width", 1)
+  feature.SetProperty("fill", color)
+  feature.SetProperty("fill-opacity", 0.3)
+  return feature
+}
+
+
+func getCellUnionAreaMeters(cellUnion s2.CellUnion) float64 {
+  area := 0.0
+  for _, cellId := range cellUnion {
+    area += s2.CellFromCellID(cellId).ExactArea()
+  }
+  return area * earthRadiusMeters * earthRadiusMeters
+}
+
+
+func getGeojsonMultiPolygonFromCellUnion(cellUnion s2.CellUnion, densify EdgeDensify) ([]string, [][][][]float64) {
   var cellIds []string
   var cellGeometry [][][][]float64
   for _, cellId := range cellUnion {
     cellIds = append(cellIds, cellId.ToToken())
-    cellGeometry = append(cellGeometry, getGeometryFromCellId(cellId))
+    cellGeometry = append(cellGeometry, getGeometryFromCellId(cellId, densify))
   }
   return cellIds, cellGeometry
 }
 
 
-func getGeometryFromCellId(cellId s2.CellID) [][][]float64 {
+func getGeometryFromCellId(cellId s2.CellID, densify EdgeDensify) [][][]float64 {
   var cellGeometry [][][]float64
   cell := s2.CellFromCellID(cellId)
+  points := []s2.Point{}
+  for k := 0; k < 4; k++ {
+    points = append(points, cell.Vertex(k))
+    points = append(points, getDensifiedEdgePoints(cell.Vertex(k), cell.Vertex((k + 1) % 4), densify)...)
+  }
+  points = append(points, cell.Vertex(0))
   vertices := [][]float64{}
-  for k := 0; k < 5; k++ {
-    vertex := cell.Vertex(k % 4)
-    latlng := s2.LatLngFromPoint(vertex)
+  for _, point := range points {
+    latlng := s2.LatLngFromPoint(point)
     vertices = append(vertices, []float64{float64(latlng.Lng.Degrees()), float64(latlng.Lat.Degrees())})
   }
   cellGeometry = [][][]float64{vertices}
@@ -497,13 +1436,130 @@ func getGeometryFromCellId(cellId s2.CellID) [][][]float64 {
 }
 
 
+// Returns the points to insert between a and b, not including a and b.
+func getDensifiedEdgePoints(a s2.Point, b s2.Point, densify EdgeDensify) []s2.Point {
+  points := []s2.Point{}
+  if densify.points > 0 {
+    for i := 1; i <= densify.points; i++ {
+      points = append(points, s2.Interpolate(float64(i) / float64(densify.points + 1), a, b))
+    }
+    return points
+  }
+  if densify.maxError > 0 {
+    return getEdgePointsWithinError(a, b, densify.maxError, 10)
+  }
+  return points
+}
+
+
+// Splits the edge at its geodesic midpoint until the midpoint is within maxError
+// meters of the straight lat/lng line between the ends.
+func getEdgePointsWithinError(a s2.Point, b s2.Point, maxError float64, maxDepth int) []s2.Point {
+  midpoint := s2.Interpolate(0.5, a, b)
+  aLatLng, bLatLng := s2.LatLngFromPoint(a), s2.LatLngFromPoint(b)
+  lngDelta := bLatLng.Lng - aLatLng.Lng
+  // Take the short way around the antimeridian, as renderers would
+  if lngDelta > 180 * s1.Degree {
+    lngDelta -= 360 * s1.Degree
+  } else if lngDelta < -180 * s1.Degree {
+    lngDelta += 360 * s1.Degree
+  }
+  planarMidpoint := s2.LatLng{Lat: (aLatLng.Lat + bLatLng.Lat) / 2, Lng: aLatLng.Lng + lngDelta / 2}.Normalized()
+  errorMeters := s2.LatLngFromPoint(midpoint).Distance(planarMidpoint).Radians() * earthRadiusMeters
+  if errorMeters <= maxError || maxDepth == 0 {
+    return []s2.Point{}
+  }
+  points := getEdgePointsWithinError(a, midpoint, maxError, maxDepth - 1)
+  points = append(points, midpoint)
+  points = append(points, getEdgePointsWithinError(midpoint, b, maxError, maxDepth - 1)...)
+  return points
+}
+
+
+// Returns the outer polygons of a Feature and the polygons formed by their holes.
+func getS2PolygonsFromFeature(feature *geojson.Feature) ([]*s2.Polygon, []*s2.Polygon) {
+  polygons := []*s2.Polygon{}
+  holePolygons := []*s2.Polygon{}
+  if feature.Geometry.IsPolygon() {
+    outerPolygon, holePolygon := getS2PolygonFromGeojsonPolygon(feature.Geometry.Polygon)
+    polygons = append(polygons, outerPolygon)
+    holePolygons = append(holePolygons, holePolygon)
+  }
+  if feature.Geometry.IsLineString() {
+    polygons = append(polygons, getS2PolygonFromGeojsonLineString(feature.Geometry.LineString))
+  }
+  if feature.Geometry.IsMultiPolygon() {
+    for _, polygon := range feature.Geometry.MultiPolygon {
+      outerPolygon, holePolygon := getS2PolygonFromGeojsonPolygon(polygon)
+      polygons = append(polygons, outerPolygon)
+      holePolygons = append(holePolygons, holePolygon)
+    }
+  }
+  return polygons, holePolygons
+}
+
+
+// Returns polygons with the holes of the Feature as inner loops, along with any of
+// the given inner loops that are within each polygon.
+func getS2PolygonsWithHolesFromFeature(feature *geojson.Feature, innerLoops []*s2.Loop) []*s2.Polygon {
+  polygons, holePolygons := getS2PolygonsFromFeature(feature)
+  polygonsWithHoles := []*s2.Polygon{}
+  for index, polygon := range polygons {
+    loops := polygon.Loops()
+    // Only LineStrings lack hole polygons, and they are the only polygon of their Feature
+    if index < len(holePolygons) {
+      loops = append(loops, holePolygons[index].Loops()...)
+    }
+    for _, innerLoop := range innerLoops {
+      if polygon.Loop(0).Contains(innerLoop) {
+        // PolygonFromLoops may reorient loops, and the inner loops are shared between Features
+        loops = append(loops, s2.LoopFromPoints(append([]s2.Point{}, innerLoop.Vertices()...)))
+      }
+    }
+    polygonsWithHoles = append(polygonsWithHoles, s2.PolygonFromLoops(loops))
+  }
+  return polygonsWithHoles
+}
+
+
+// Returns the loops of inner members by relation id. A Feature can be an inner member of
+// some relations and an outer member of others.
+func getRelationInnerLoops(features []*geojson.Feature) map[int][]*s2.Loop {
+  relationInnerLoops := map[int][]*s2.Loop{}
+  for _, feature := range features {
+    for _, membership := range getRelationMembershipsForFeature(feature) {
+      if membership.role != "inner" {
+        continue
+      }
+      polygons, _ := getS2PolygonsFromFeature(feature)
+      for _, polygon := range polygons {
+        relationInnerLoops[membership.id] = append(relationInnerLoops[membership.id], polygon.Loops()...)
+      }
+    }
+  }
+  return relationInnerLoops
+}
+
+
+// Returns the inner loops of the relations the Feature is an outer member of.
+func getOuterRelationInnerLoops(feature *geojson.Feature, relationInnerLoops map[int][]*s2.Loop) []*s2.Loop {
+  innerLoops := []*s2.Loop{}
+  for _, membership := range getRelationMembershipsForFeature(feature) {
+    if membership.role != "inner" {
+      innerLoops = append(innerLoops, relationInnerLoops[membership.id]...)
+    }
+  }
+  return innerLoops
+}
+
+
 func getS2PolygonFromGeojsonPolygon(geojsonPolygon [][][]float64) (*s2.Polygon, *s2.Polygon) {
   var outerLoops []*s2.Loop
   var innerLoops []*s2.Loop
   for index, ring := range geojsonPolygon {
     // In GeoJSON, the first ring is an outer ring, the rest are holes
     isHole := index > 0
-    loop := getS2LoopFromGeojsonRing(ring, isHole)
+    loop := getS2LoopFromGeojsonRing(ring)
     if isHole {
       innerLoops = append(innerLoops, loop)
     } else {
@@ -515,46 +1571,53 @@ func getS2PolygonFromGeojsonPolygon(geojsonPolygon [][][]float64) (*s2.Polygon,
 
 
 func getS2PolygonFromGeojsonLineString(geojsonLineString [][]float64) *s2.Polygon {
-  loop := getS2LoopFromGeojsonRing(geojsonLineString, false)
+  loop := getS2LoopFromGeojsonRing(geojsonLineString)
   return s2.PolygonFromLoops([]*s2.Loop{loop})
 }
 
 
-func getS2LoopFromGeojsonRing(ring [][]float64, isHole bool) *s2.Loop {
+// Rings are repaired and oriented to enclose the smaller area, whatever their
+// position or winding in the GeoJSON, as OSM data often has holes wound either way.
+func getS2LoopFromGeojsonRing(ring [][]float64) *s2.Loop {
   var points []s2.Point
   for _, latlngMap := range ring {
     latlng := s2.LatLngFromDegrees(latlngMap[1], latlngMap[0])
     point := s2.PointFromLatLng(latlng)
     points = append(points, point)
   }
-  // GeoJSON polygon rings must end with the starting point.
-  // S2 polygons must not have identical vertices,
-  // and do not need to end with the starting point.
-  // We omit the last point, if it"s same as first.
-  if points[0] == points[len(points) - 1] {
-    points = points[:len(points) - 1]
-  }
-  // In GeoJSON holes must be clockwise, exteriors counterclockwise.
-  // In S2 all loops must be counterclockwise.
-  if isHole {
-    points = reverseS2Points(points)
-  }
-  loop := s2.LoopFromPoints(points)
-  loop.Normalize()
-  return loop
+  points, _ = getRepairedRingPoints(points)
+  return getOrientedLoop(points)
 }
 
 
+// Lists every relation of the Feature, e.g. relation/1,2/way/3
 func getPathForFeature(feature *geojson.Feature) string {
   path := ""
-  if feature.Properties["@relations"] != nil {
-    path += fmt.Sprintf("relation/%d/", int(feature.Properties["@relations"].([]interface{})[0].(map[string]interface{})["rel"].(float64)))
+  relationIds := []string{}
+  for _, membership := range getRelationMembershipsForFeature(feature) {
+    relationIds = append(relationIds, strconv.Itoa(membership.id))
   }
-  path += feature.ID.(string)
+  if len(relationIds) > 0 {
+    path += fmt.Sprintf("relation/%s/", strings.Join(relationIds, ","))
+  }
+  path += getIdTextForFeature(feature)
   return path
 }
 
 
+// Numeric ids are decoded as float64, which fmt.Sprint would write in exponent form.
+func getIdTextForFeature(feature *geojson.Feature) string {
+  switch id := feature.ID.(type) {
+  case nil:
+    return ""
+  case float64:
+    return strconv.FormatFloat(id, 'f', -1, 64)
+  default:
+    return fmt.Sprint(id)
+  }
+}
+
+
 func reverseS2Points(sp []s2.Point) []s2.Point {
   for i, j := 0, len(sp) - 1; i < j; i, j = i + 1, j - 1 {
     sp[i], sp[j] = sp[j], sp[i]
@@ -0,0 +1,72 @@
+package main
+
+import (
+  "strconv"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+type FeatureCovering struct {
+  path string
+  covering s2.CellUnion
+}
+
+
+type FeatureOverlap struct {
+  pathA string
+  pathB string
+  cells s2.CellUnion
+  area float64
+}
+
+
+// Compares every pair of coverings, skipping pairs whose bounding rectangles are disjoint.
+func getFeatureOverlaps(featureCoverings []FeatureCovering) []FeatureOverlap {
+  overlaps := []FeatureOverlap{}
+  rects := []s2.Rect{}
+  for _, featureCovering := range featureCoverings {
+    rects = append(rects, featureCovering.covering.RectBound())
+  }
+  for i := 0; i < len(featureCoverings); i++ {
+    for j := i + 1; j < len(featureCoverings); j++ {
+      if ! rects[i].Intersects(rects[j]) || ! featureCoverings[i].covering.Intersects(featureCoverings[j].covering) {
+        continue
+      }
+      cells := s2.CellUnionFromIntersection(featureCoverings[i].covering, featureCoverings[j].covering)
+      overlaps = append(overlaps, FeatureOverlap{
+        pathA: featureCoverings[i].path,
+        pathB: featureCoverings[j].path,
+        cells: cells,
+        area: getCellUnionAreaMeters(cells)})
+    }
+  }
+  return overlaps
+}
+
+
+func getFeatureOverlapsFeatureCollection(overlaps []FeatureOverlap, overlapColor string, densify EdgeDensify) *geojson.FeatureCollection {
+  featureCollection := geojson.NewFeatureCollection()
+  for _, overlap := range overlaps {
+    cellIds, cellGeometry := getGeojsonMultiPolygonFromCellUnion(overlap.cells, densify)
+    feature := geojson.NewMultiPolygonFeature(cellGeometry...)
+    feature.SetProperty("features", []string{overlap.pathA, overlap.pathB})
+    feature.SetProperty("cellids", cellIds)
+    feature.SetProperty("area", overlap.area)
+    feature.SetProperty("stroke", overlapColor)
+    feature.SetProperty("stroke-width", 1)
+    feature.SetProperty("fill", overlapColor)
+    feature.SetProperty("fill-opacity", 0.3)
+    featureCollection.AddFeature(feature)
+  }
+  return featureCollection
+}
+
+
+func getFeatureOverlapRows(overlaps []FeatureOverlap) [][]string {
+  rows := [][]string{}
+  for _, overlap := range overlaps {
+    rows = append(rows, []string{overlap.pathA, overlap.pathB, strconv.Itoa(len(overlap.cells)), strconv.FormatFloat(overlap.area, 'f', 2, 64)})
+  }
+  return rows
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+type PartitionCandidate struct {
+  featureIndex int
+  fraction float64
+  priority float64
+}
+
+
+// Cells assigned to each Feature, keyed by the index of the Feature.
+type Partition struct {
+  cellIds map[int]s2.CellUnion
+  cellFractions map[int][]float64
+  conflictRows [][]string
+}
+
+
+// Assigns every cell of the given level touching a Feature to exactly one Feature.
+// A cell touching several Features goes to the one with the highest priority property,
+// then to the one with most of the cell's area, and each such cell is reported as a conflict.
+// Inner Features are not part of the partition.
+func getPartition(features []*geojson.Feature, partitionLevel int, priorityProperty string, maxCells int) (Partition, error) {
+  partition := Partition{cellIds: map[int]s2.CellUnion{}, cellFractions: map[int][]float64{}, conflictRows: [][]string{}}
+  candidatesByCell := map[s2.CellID][]PartitionCandidate{}
+  for index, feature := range features {
+    if getRoleForFeature(feature) == "inner" {
+      continue
+    }
+    priority, err := getPartitionPriority(feature, priorityProperty)
+    if err != nil {
+      return partition, err
+    }
+    polygons := getS2PolygonsWithHolesFromFeature(feature, []*s2.Loop{})
+    cellIds, cellFractions, err := getFractionCellIds(polygons, partitionLevel, 0, maxCells)
+    if err != nil {
+      return partition, fmt.Errorf("%s has %v, use a higher -partitionlevel or -maxgridcells", getPathForFeature(feature), err)
+    }
+    for i, cellId := range cellIds {
+      candidatesByCell[cellId] = append(candidatesByCell[cellId], PartitionCandidate{featureIndex: index, fraction: cellFractions[i], priority: priority})
+    }
+  }
+
+  cellIds := s2.CellUnion{}
+  for cellId := range candidatesByCell {
+    cellIds = append(cellIds, cellId)
+  }
+  sort.Slice(cellIds, func(i, j int) bool { return cellIds[i] < cellIds[j] })
+  for _, cellId := range cellIds {
+    candidates := candidatesByCell[cellId]
+    winner := candidates[0]
+    for _, candidate := range candidates[1:] {
+      if candidate.priority > winner.priority || (candidate.priority == winner.priority && candidate.fraction > winner.fraction) {
+        winner = candidate
+      }
+    }
+    partition.cellIds[winner.featureIndex] = append(partition.cellIds[winner.featureIndex], cellId)
+    partition.cellFractions[winner.featureIndex] = append(partition.cellFractions[winner.featureIndex], winner.fraction)
+    if len(candidates) > 1 {
+      contenders := []string{}
+      for _, candidate := range candidates {
+        contenders = append(contenders, fmt.Sprintf("%s:%.4f", getPathForFeature(features[candidate.featureIndex]), candidate.fraction))
+      }
+      partition.conflictRows = append(partition.conflictRows, []string{cellId.ToToken(), getPathForFeature(features[winner.featureIndex]), strings.Join(contenders, " ")})
+    }
+  }
+  return partition, nil
+}
+
+
+// Features without the property have priority 0.
+func getPartitionPriority(feature *geojson.Feature, priorityProperty string) (float64, error) {
+  if priorityProperty == "" || feature.Properties[priorityProperty] == nil {
+    return 0, nil
+  }
+  switch value := feature.Properties[priorityProperty].(type) {
+  case float64:
+    return value, nil
+  case string:
+    priority, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+      return 0, fmt.Errorf("%s of %s is not a number: %q", priorityProperty, getPathForFeature(feature), value)
+    }
+    return priority, nil
+  }
+  return 0, fmt.Errorf("%s of %s is not a number", priorityProperty, getPathForFeature(feature))
+}
+
+
@@ -0,0 +1,152 @@
+package main
+
+import (
+  "fmt"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+var polygonProblemsCsvHeader = []string{"path", "name", "location", "status", "problem"}
+
+
+// Removes the closing point, repeated vertices and spikes going back along the
+// previous edge, none of which S2 loops allow. Returns descriptions of the repairs.
+func getRepairedRingPoints(points []s2.Point) ([]s2.Point, []string) {
+  repairs := []string{}
+  // GeoJSON polygon rings must end with the starting point.
+  // S2 loops must not have identical vertices,
+  // and do not need to end with the starting point.
+  if len(points) > 1 && points[0] == points[len(points) - 1] {
+    points = points[:len(points) - 1]
+  }
+  duplicates := 0
+  spikes := 0
+  repaired := []s2.Point{}
+  for _, point := range points {
+    if len(repaired) > 0 && repaired[len(repaired) - 1] == point {
+      duplicates++
+      continue
+    }
+    if len(repaired) > 1 && repaired[len(repaired) - 2] == point {
+      repaired = repaired[:len(repaired) - 1]
+      spikes++
+      continue
+    }
+    repaired = append(repaired, point)
+  }
+  // The ring wraps around, so repeat at the start until nothing changes
+  for len(repaired) > 1 {
+    if repaired[0] == repaired[len(repaired) - 1] {
+      repaired = repaired[:len(repaired) - 1]
+      duplicates++
+    } else if len(repaired) > 2 && repaired[1] == repaired[len(repaired) - 1] {
+      repaired = repaired[1:len(repaired) - 1]
+      spikes++
+    } else if len(repaired) > 2 && repaired[0] == repaired[len(repaired) - 2] {
+      repaired = repaired[:len(repaired) - 1]
+      spikes++
+    } else {
+      break
+    }
+  }
+  if duplicates > 0 {
+    repairs = append(repairs, fmt.Sprintf("removed %d duplicate vertices", duplicates))
+  }
+  if spikes > 0 {
+    repairs = append(repairs, fmt.Sprintf("removed %d spikes", spikes))
+  }
+  return repaired, repairs
+}
+
+
+// Winding is detected from the turning angle of the loop: a clockwise loop would
+// enclose more than half of the sphere, so its vertices are reversed.
+func getOrientedLoop(points []s2.Point) *s2.Loop {
+  loop := s2.LoopFromPoints(points)
+  if len(points) >= 3 && loop.TurningAngle() < 0 {
+    loop = s2.LoopFromPoints(reverseS2Points(points))
+  }
+  return loop
+}
+
+
+// Counts the pairs of edges of the ring crossing each other. Polygon.Validate does not
+// check for crossings, and an index keeps rings with many vertices from being compared
+// edge by edge.
+func getSelfIntersectionCount(points []s2.Point) int {
+  loop := s2.LoopFromPoints(points)
+  index := s2.NewShapeIndex()
+  index.Add(loop)
+  query := s2.NewCrossingEdgeQuery(index)
+  crossings := 0
+  for i := 0; i < loop.NumEdges(); i++ {
+    edge := loop.Edge(i)
+    // Adjacent edges only share a vertex, so they never cross in their interiors
+    for _, j := range query.Crossings(edge.V0, edge.V1, loop, s2.CrossingTypeInterior) {
+      if j > i {
+        crossings++
+      }
+    }
+  }
+  return crossings
+}
+
+
+// Lists the repairs made to the rings of the Feature, and the problems left after them.
+func getPolygonProblemRows(feature *geojson.Feature) [][]string {
+  rows := [][]string{}
+  path, name := getPathForFeature(feature), getNameForFeature(feature)
+  hasDegenerateRing := false
+  geojsonPolygons := [][][][]float64{}
+  if feature.Geometry.IsPolygon() {
+    geojsonPolygons = append(geojsonPolygons, feature.Geometry.Polygon)
+  }
+  if feature.Geometry.IsMultiPolygon() {
+    geojsonPolygons = feature.Geometry.MultiPolygon
+  }
+  if feature.Geometry.IsLineString() {
+    geojsonPolygons = append(geojsonPolygons, [][][]float64{feature.Geometry.LineString})
+  }
+  for polygonIndex, geojsonPolygon := range geojsonPolygons {
+    for ringIndex, ring := range geojsonPolygon {
+      location := fmt.Sprintf("polygon %d ring %d", polygonIndex, ringIndex)
+      points := []s2.Point{}
+      for _, latlngMap := range ring {
+        points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(latlngMap[1], latlngMap[0])))
+      }
+      points, repairs := getRepairedRingPoints(points)
+      for _, repair := range repairs {
+        rows = append(rows, []string{path, name, location, "repaired", repair})
+      }
+      if len(points) < 3 {
+        rows = append(rows, []string{path, name, location, "invalid", fmt.Sprintf("only %d distinct vertices", len(points))})
+        hasDegenerateRing = true
+        continue
+      }
+      crossings := getSelfIntersectionCount(points)
+      if crossings > 0 {
+        rows = append(rows, []string{path, name, location, "invalid", fmt.Sprintf("%d self-intersections", crossings)})
+      }
+      // RFC 7946 requires exterior rings to be counterclockwise and holes clockwise
+      isClockwise := s2.LoopFromPoints(points).TurningAngle() < 0
+      if isClockwise && ringIndex == 0 {
+        rows = append(rows, []string{path, name, location, "repaired", "clockwise exterior ring"})
+      } else if ! isClockwise && ringIndex > 0 {
+        rows = append(rows, []string{path, name, location, "repaired", "counterclockwise hole"})
+      }
+    }
+  }
+  if hasDegenerateRing {
+    return rows
+  }
+  for index, polygon := range getS2PolygonsWithHolesFromFeature(feature, []*s2.Loop{}) {
+    err := polygon.Validate()
+    if err != nil {
+      rows = append(rows, []string{path, name, fmt.Sprintf("polygon %d", index), "invalid", err.Error()})
+    }
+  }
+  return rows
+}
+
+
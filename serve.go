@@ -0,0 +1,220 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "strconv"
+  "github.com/paulmach/go.geojson"
+)
+
+
+// Highest maxcells accepted by /cover
+const serveMaxCellsLimit = 10000
+
+
+type FeatureCellIds struct {
+  Path string `json:"path"`
+  Name string `json:"name"`
+  CellIds []string `json:"cellids"`
+  HoleCellIds []string `json:"holecellids"`
+}
+
+
+func serveMain(args []string) {
+  flagSet := flag.NewFlagSet("serve", flag.ExitOnError)
+  address := flagSet.String("addr", "localhost:8080", "Address to listen on")
+  featuresFilePath := flagSet.String("features", "", "GeoJSON of Features for /contains queries")
+  maxLevel := flagSet.Int("maxlevel", 20, "Default MaxLevel setting for RegionCoverer")
+  minLevel := flagSet.Int("minlevel", 5, "Default MinLevel setting for RegionCoverer")
+  maxCells := flagSet.Int("maxcells", 1000, "Default MaxCells setting for RegionCoverer")
+  flagSet.Usage = func() {
+    fmt.Fprintln(os.Stderr, "Usage: osmcoverer serve [options]")
+    flagSet.PrintDefaults()
+  }
+  flagSet.Parse(args)
+
+  coveredFeatures := []CoveredFeature{}
+  if *featuresFilePath != "" {
+    for _, feature := range getFeatureCollectionFromGeojson(*featuresFilePath).Features {
+      coveredFeatures = append(coveredFeatures, getCoveredFeature(feature, *maxLevel, *minLevel, *maxCells, EdgeDensify{}))
+    }
+    fmt.Println("Loaded", len(coveredFeatures), "Features")
+  }
+  fmt.Println("Listening on", *address)
+  err := http.ListenAndServe(*address, newServeMux(coveredFeatures, *maxLevel, *minLevel, *maxCells))
+  check(err)
+}
+
+
+// POST /cover takes a GeoJSON Feature or FeatureCollection and returns the coverings
+// as GeoJSON, or as cell id tokens with format=tokens. maxlevel, minlevel and maxcells
+// query parameters override the defaults.
+// GET /contains?lat=&lng= returns the point as a GeoJSON marker, with the paths
+// of the loaded Features containing it in within and centerwithin.
+func newServeMux(coveredFeatures []CoveredFeature, maxLevel int, minLevel int, maxCells int) *http.ServeMux {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/cover", func(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+      http.Error(w, "POST a GeoJSON Feature or FeatureCollection", http.StatusMethodNotAllowed)
+      return
+    }
+    requestMaxLevel, requestMinLevel, requestMaxCells, err := getCoverParameters(r, maxLevel, minLevel, maxCells)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+    features, err := getFeaturesFromGeojson(body)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+
+    featureCellIds := []FeatureCellIds{}
+    featureCollection := geojson.NewFeatureCollection()
+    for _, feature := range features {
+      coveredFeature := getCoveredFeature(feature, requestMaxLevel, requestMinLevel, requestMaxCells, EdgeDensify{})
+      featureCellIds = append(featureCellIds, FeatureCellIds{
+        Path: getPathForFeature(feature),
+        Name: coveredFeature.name,
+        CellIds: coveredFeature.cellIds,
+        HoleCellIds: coveredFeature.holeCellIds})
+      if len(coveredFeature.cellIds) > 0 {
+        cellFeature := geojson.NewMultiPolygonFeature(coveredFeature.cellGeometry...)
+        cellFeature.SetProperty("path", getPathForFeature(feature))
+        cellFeature.SetProperty("cellids", coveredFeature.cellIds)
+        featureCollection.AddFeature(cellFeature)
+      }
+      if len(coveredFeature.holeCellIds) > 0 {
+        holeCellFeature := geojson.NewMultiPolygonFeature(coveredFeature.holeCellGeometry...)
+        holeCellFeature.SetProperty("path", getPathForFeature(feature))
+        holeCellFeature.SetProperty("holecellids", coveredFeature.holeCellIds)
+        featureCollection.AddFeature(holeCellFeature)
+      }
+    }
+    if r.URL.Query().Get("format") == "tokens" {
+      writeJson(w, featureCellIds)
+    } else {
+      writeJson(w, featureCollection)
+    }
+  })
+  mux.HandleFunc("/contains", func(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+      http.Error(w, "GET with lat and lng parameters", http.StatusMethodNotAllowed)
+      return
+    }
+    lat, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+    if err != nil {
+      http.Error(w, "invalid lat", http.StatusBadRequest)
+      return
+    }
+    lng, err := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+    if err != nil {
+      http.Error(w, "invalid lng", http.StatusBadRequest)
+      return
+    }
+    marker := getMarkerFromLatLng("", lat, lng, "#7e7e7e", []int{}, maxLevel)
+    markers := []Marker{marker}
+    for _, coveredFeature := range coveredFeatures {
+      containedMarkers, _, _ := checkContainedMarkerFeatures(coveredFeature.covering, coveredFeature.holeCovering, maxLevel, coveredFeature.isHole, coveredFeature.feature, markers)
+      addRelationHoleLabels(coveredFeature.feature, containedMarkers, "within")
+      containedMarkers, _ = checkContainedCellCenters(coveredFeature.polygons, coveredFeature.isHole, coveredFeature.feature, markers, []Marker{})
+      addRelationHoleLabels(coveredFeature.feature, containedMarkers, "centerwithin")
+      checkContainedCellCenters(coveredFeature.holePolygons, true, coveredFeature.feature, markers, []Marker{})
+    }
+    writeJson(w, marker.feature)
+  })
+  return mux
+}
+
+
+// Accepts both a FeatureCollection and a single Feature. Features that cannot
+// be covered are rejected here, instead of panicking in the handler.
+func getFeaturesFromGeojson(data []byte) ([]*geojson.Feature, error) {
+  features := []*geojson.Feature{}
+  featureCollection, err := geojson.UnmarshalFeatureCollection(data)
+  if err == nil && featureCollection.Type == "FeatureCollection" {
+    features = featureCollection.Features
+  } else {
+    feature, err := geojson.UnmarshalFeature(data)
+    if err != nil {
+      return nil, err
+    }
+    if feature.Geometry == nil {
+      return nil, fmt.Errorf("expected a GeoJSON Feature or FeatureCollection")
+    }
+    features = append(features, feature)
+  }
+  for _, feature := range features {
+    if feature == nil {
+      return nil, fmt.Errorf("null Feature in FeatureCollection")
+    }
+    err = getFeatureInputError(feature)
+    if err != nil {
+      return nil, err
+    }
+  }
+  return features, nil
+}
+
+
+// Unchecked settings can keep RegionCoverer busy for minutes, or forever when minlevel > maxlevel.
+func getCoverParameters(r *http.Request, maxLevel int, minLevel int, maxCells int) (int, int, int, error) {
+  requestMaxLevel, err := getIntParameter(r, "maxlevel", maxLevel)
+  if err != nil {
+    return 0, 0, 0, err
+  }
+  requestMinLevel, err := getIntParameter(r, "minlevel", minLevel)
+  if err != nil {
+    return 0, 0, 0, err
+  }
+  requestMaxCells, err := getIntParameter(r, "maxcells", maxCells)
+  if err != nil {
+    return 0, 0, 0, err
+  }
+  if requestMaxLevel < 0 || requestMaxLevel > 30 {
+    return 0, 0, 0, fmt.Errorf("maxlevel %d is not between 0 and 30", requestMaxLevel)
+  }
+  if requestMinLevel < 0 || requestMinLevel > 30 {
+    return 0, 0, 0, fmt.Errorf("minlevel %d is not between 0 and 30", requestMinLevel)
+  }
+  if requestMinLevel > requestMaxLevel {
+    return 0, 0, 0, fmt.Errorf("minlevel %d is greater than maxlevel %d", requestMinLevel, requestMaxLevel)
+  }
+  if requestMaxCells < 1 || requestMaxCells > serveMaxCellsLimit {
+    return 0, 0, 0, fmt.Errorf("maxcells %d is not between 1 and %d", requestMaxCells, serveMaxCellsLimit)
+  }
+  return requestMaxLevel, requestMinLevel, requestMaxCells, nil
+}
+
+
+func getIntParameter(r *http.Request, name string, defaultValue int) (int, error) {
+  text := r.URL.Query().Get(name)
+  if text == "" {
+    return defaultValue, nil
+  }
+  value, err := strconv.Atoi(text)
+  if err != nil {
+    return 0, fmt.Errorf("invalid %s", name)
+  }
+  return value, nil
+}
+
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+  data, err := json.Marshal(value)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.Write(data)
+}
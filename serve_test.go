@@ -0,0 +1,187 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "github.com/paulmach/go.geojson"
+)
+
+
+// A square of roughly 1 km by 1 km
+const testSquareGeojson = `{"type": "Feature", "id": "way/1", "properties": {"name": "Square"},
+  "geometry": {"type": "Polygon", "coordinates": [[[24.93, 60.16], [24.95, 60.16], [24.95, 60.17], [24.93, 60.17], [24.93, 60.16]]]}}`
+
+
+func newTestServeMux(t *testing.T) *http.ServeMux {
+  feature, err := geojson.UnmarshalFeature([]byte(testSquareGeojson))
+  if err != nil {
+    t.Fatal(err)
+  }
+  coveredFeatures := []CoveredFeature{getCoveredFeature(feature, 16, 5, 100, EdgeDensify{})}
+  return newServeMux(coveredFeatures, 16, 5, 100)
+}
+
+
+func serveTestRequest(mux *http.ServeMux, method string, url string, body string) *httptest.ResponseRecorder {
+  recorder := httptest.NewRecorder()
+  mux.ServeHTTP(recorder, httptest.NewRequest(method, url, strings.NewReader(body)))
+  return recorder
+}
+
+
+func TestCoverGeojson(t *testing.T) {
+  recorder := serveTestRequest(newTestServeMux(t), http.MethodPost, "/cover", testSquareGeojson)
+  if recorder.Code != http.StatusOK {
+    t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+  }
+  featureCollection, err := geojson.UnmarshalFeatureCollection(recorder.Body.Bytes())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(featureCollection.Features) != 1 {
+    t.Fatalf("expected 1 cell Feature, got %d", len(featureCollection.Features))
+  }
+  cellFeature := featureCollection.Features[0]
+  if cellFeature.Properties["path"] != "way/1" {
+    t.Errorf("expected path way/1, got %v", cellFeature.Properties["path"])
+  }
+  if cellIds, ok := cellFeature.Properties["cellids"].([]interface{}); ! ok || len(cellIds) == 0 {
+    t.Errorf("expected cellids, got %v", cellFeature.Properties["cellids"])
+  }
+}
+
+
+func TestCoverTokens(t *testing.T) {
+  recorder := serveTestRequest(newTestServeMux(t), http.MethodPost, "/cover?format=tokens&minlevel=8&maxlevel=12&maxcells=20", testSquareGeojson)
+  if recorder.Code != http.StatusOK {
+    t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+  }
+  featureCellIds := []FeatureCellIds{}
+  err := json.Unmarshal(recorder.Body.Bytes(), &featureCellIds)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(featureCellIds) != 1 || featureCellIds[0].Path != "way/1" || featureCellIds[0].Name != "Square" {
+    t.Fatalf("unexpected result %+v", featureCellIds)
+  }
+  if len(featureCellIds[0].CellIds) == 0 || len(featureCellIds[0].CellIds) > 20 {
+    t.Errorf("expected 1 to 20 cell ids, got %d", len(featureCellIds[0].CellIds))
+  }
+}
+
+
+func TestCoverNumericId(t *testing.T) {
+  body := strings.Replace(testSquareGeojson, `"id": "way/1"`, `"id": 123456789`, 1)
+  recorder := serveTestRequest(newTestServeMux(t), http.MethodPost, "/cover?format=tokens", body)
+  featureCellIds := []FeatureCellIds{}
+  err := json.Unmarshal(recorder.Body.Bytes(), &featureCellIds)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(featureCellIds) != 1 || featureCellIds[0].Path != "123456789" {
+    t.Errorf("expected path 123456789, got %+v", featureCellIds)
+  }
+}
+
+
+func TestCoverBadRequests(t *testing.T) {
+  withProperties := func(properties string) string {
+    return strings.Replace(testSquareGeojson, `{"name": "Square"}`, properties, 1)
+  }
+  requests := []struct {
+    name string
+    url string
+    body string
+  }{
+    {"minlevel above maxlevel", "/cover?minlevel=25&maxlevel=10", testSquareGeojson},
+    {"maxlevel above 30", "/cover?maxlevel=31", testSquareGeojson},
+    {"negative minlevel", "/cover?minlevel=-1", testSquareGeojson},
+    {"zero maxcells", "/cover?maxcells=0", testSquareGeojson},
+    {"maxcells above limit", fmt.Sprintf("/cover?maxcells=%d", serveMaxCellsLimit + 1), testSquareGeojson},
+    {"invalid maxcells", "/cover?maxcells=many", testSquareGeojson},
+    {"invalid json", "/cover", "{"},
+    {"no geometry", "/cover", `{"type": "Feature", "properties": {}}`},
+    {"no geometry in collection", "/cover", `{"type": "FeatureCollection", "features": [{"type": "Feature", "properties": {}, "geometry": null}]}`},
+    {"relation without rel", "/cover", withProperties(`{"@relations": [{"role": "outer", "reltags": {}}]}`)},
+    {"relation not an object", "/cover", withProperties(`{"@relations": ["relation/1"]}`)},
+    {"relations not a list", "/cover", withProperties(`{"@relations": {"rel": 1}}`)},
+    {"position without latitude", "/cover", strings.Replace(testSquareGeojson, "[24.95, 60.16]", "[24.95]", 1)},
+  }
+  mux := newTestServeMux(t)
+  for _, request := range requests {
+    recorder := serveTestRequest(mux, http.MethodPost, request.url, request.body)
+    if recorder.Code != http.StatusBadRequest {
+      t.Errorf("%s: expected status 400, got %d", request.name, recorder.Code)
+    }
+  }
+  recorder := serveTestRequest(mux, http.MethodGet, "/cover", "")
+  if recorder.Code != http.StatusMethodNotAllowed {
+    t.Errorf("GET /cover: expected status 405, got %d", recorder.Code)
+  }
+}
+
+
+func TestContains(t *testing.T) {
+  mux := newTestServeMux(t)
+  requests := []struct {
+    url string
+    within []string
+  }{
+    {"/contains?lat=60.165&lng=24.94", []string{"way/1"}},
+    {"/contains?lat=61&lng=25", []string{}},
+  }
+  for _, request := range requests {
+    recorder := serveTestRequest(mux, http.MethodGet, request.url, "")
+    if recorder.Code != http.StatusOK {
+      t.Fatalf("%s: status %d: %s", request.url, recorder.Code, recorder.Body.String())
+    }
+    marker, err := geojson.UnmarshalFeature(recorder.Body.Bytes())
+    if err != nil {
+      t.Fatal(err)
+    }
+    within := fmt.Sprint(marker.Properties["within"])
+    if within != fmt.Sprint(request.within) {
+      t.Errorf("%s: expected within %v, got %s", request.url, request.within, within)
+    }
+  }
+}
+
+
+func TestContainsBadRequests(t *testing.T) {
+  mux := newTestServeMux(t)
+  for _, url := range []string{"/contains", "/contains?lat=60.165", "/contains?lat=60.165&lng=east"} {
+    recorder := serveTestRequest(mux, http.MethodGet, url, "")
+    if recorder.Code != http.StatusBadRequest {
+      t.Errorf("%s: expected status 400, got %d", url, recorder.Code)
+    }
+  }
+  recorder := serveTestRequest(mux, http.MethodPost, "/contains?lat=60.165&lng=24.94", "")
+  if recorder.Code != http.StatusMethodNotAllowed {
+    t.Errorf("POST /contains: expected status 405, got %d", recorder.Code)
+  }
+}
+
+
+func TestContainsRelationHole(t *testing.T) {
+  body := strings.Replace(testSquareGeojson, `{"name": "Square"}`, `{"@relations": [{"rel": 1, "role": "outer"}, {"rel": 2, "role": "inner"}]}`, 1)
+  feature, err := geojson.UnmarshalFeature([]byte(body))
+  if err != nil {
+    t.Fatal(err)
+  }
+  mux := newServeMux([]CoveredFeature{getCoveredFeature(feature, 16, 5, 100, EdgeDensify{})}, 16, 5, 100)
+  recorder := serveTestRequest(mux, http.MethodGet, "/contains?lat=60.165&lng=24.94", "")
+  marker, err := geojson.UnmarshalFeature(recorder.Body.Bytes())
+  if err != nil {
+    t.Fatal(err)
+  }
+  expected := "[relation/1,2/way/1 relation/2/way/1 (hole)]"
+  for _, property := range []string{"within", "centerwithin"} {
+    if within := fmt.Sprint(marker.Properties[property]); within != expected {
+      t.Errorf("expected %s %s, got %s", property, expected, within)
+    }
+  }
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+// Covers two selections of Features and outputs the union, intersection
+// and differences of their coverings.
+func setopsMain(args []string) {
+  flagSet := flag.NewFlagSet("setops", flag.ExitOnError)
+  selectionA := flagSet.String("a", "", "First selection: Feature path, id or name in the input file, or a GeoJSON file")
+  selectionB := flagSet.String("b", "", "Second selection: Feature path, id or name in the input file, or a GeoJSON file")
+  maxLevel := flagSet.Int("maxlevel", 20, "MaxLevel setting for RegionCoverer")
+  minLevel := flagSet.Int("minlevel", 5, "MinLevel setting for RegionCoverer")
+  maxCells := flagSet.Int("maxcells", 1000, "MaxCells setting for RegionCoverer")
+  outputDirectory := flagSet.String("outdir", "output", "Output directory")
+  shouldIndent := flagSet.Bool("pretty", true, "Output pretty printend GeoJSON")
+  unionColor := flagSet.String("cu", "#0000ff", "Union cells color")
+  intersectionColor := flagSet.String("ci", "#800080", "Intersection cells color")
+  differenceColor := flagSet.String("cd", "#ff8080", "Difference cells color")
+  flagSet.Usage = func() {
+    fmt.Fprintln(os.Stderr, "Usage: osmcoverer setops -a <selection> -b <selection> [options] [<input file>]")
+    flagSet.PrintDefaults()
+  }
+  flagSet.Parse(args)
+  if *selectionA == "" || *selectionB == "" {
+    flagSet.Usage()
+    os.Exit(2)
+  }
+  os.MkdirAll(*outputDirectory, os.ModePerm)
+
+  coveredFeatures := []CoveredFeature{}
+  if flagSet.NArg() > 0 {
+    for _, feature := range getFeatureCollectionFromGeojson(flagSet.Arg(0)).Features {
+      coveredFeatures = append(coveredFeatures, getCoveredFeature(feature, *maxLevel, *minLevel, *maxCells, EdgeDensify{}))
+    }
+  }
+  coveringA, err := getSelectionCovering(*selectionA, coveredFeatures, *maxLevel, *minLevel, *maxCells)
+  check(err)
+  coveringB, err := getSelectionCovering(*selectionB, coveredFeatures, *maxLevel, *minLevel, *maxCells)
+  check(err)
+
+  results := []struct {
+    operation string
+    color string
+    cellUnion s2.CellUnion
+  }{
+    {"union", *unionColor, s2.CellUnionFromUnion(coveringA, coveringB)},
+    {"intersection", *intersectionColor, s2.CellUnionFromIntersection(coveringA, coveringB)},
+    {"difference_ab", *differenceColor, s2.CellUnionFromDifference(coveringA, coveringB)},
+    {"difference_ba", *differenceColor, s2.CellUnionFromDifference(coveringB, coveringA)},
+  }
+
+  fmt.Println("A:", fmt.Sprintf("%d cells, %.0f m²", len(coveringA), getCellUnionAreaMeters(coveringA)))
+  fmt.Println("B:", fmt.Sprintf("%d cells, %.0f m²", len(coveringB), getCellUnionAreaMeters(coveringB)))
+  featureCollection := geojson.NewFeatureCollection()
+  for _, result := range results {
+    area := getCellUnionAreaMeters(result.cellUnion)
+    fmt.Println(result.operation + ":", fmt.Sprintf("%d cells, %.0f m²", len(result.cellUnion), area))
+    cellIds, cellGeometry := getGeojsonMultiPolygonFromCellUnion(result.cellUnion, EdgeDensify{})
+    err = ioutil.WriteFile(fmt.Sprintf("%s/%s.txt", *outputDirectory, result.operation), []byte(strings.Join(cellIds, "\n") + "\n"), 0644)
+    check(err)
+    if len(cellIds) == 0 {
+      continue
+    }
+    cellFeature := geojson.NewMultiPolygonFeature(cellGeometry...)
+    cellFeature.SetProperty("operation", result.operation)
+    cellFeature.SetProperty("cellids", cellIds)
+    cellFeature.SetProperty("area", area)
+    cellFeature.SetProperty("stroke", result.color)
+    cellFeature.SetProperty("stroke-width", 1)
+    cellFeature.SetProperty("fill", result.color)
+    cellFeature.SetProperty("fill-opacity", 0.3)
+    featureCollection.AddFeature(cellFeature)
+  }
+
+  writeGeojsonFile(featureCollection, fmt.Sprintf("%s/setops.geojson", *outputDirectory), *shouldIndent)
+}
+
+
+// Hole coverings and the coverings of inner Features are subtracted from the selection.
+func getSelectionCovering(selection string, coveredFeatures []CoveredFeature, maxLevel int, minLevel int, maxCells int) (s2.CellUnion, error) {
+  selectedFeatures := []CoveredFeature{}
+  if strings.ToLower(filepath.Ext(selection)) == ".geojson" {
+    for _, feature := range getFeatureCollectionFromGeojson(selection).Features {
+      selectedFeatures = append(selectedFeatures, getCoveredFeature(feature, maxLevel, minLevel, maxCells, EdgeDensify{}))
+    }
+  } else {
+    for _, coveredFeature := range coveredFeatures {
+      if featureMatchesSelection(coveredFeature, selection) {
+        selectedFeatures = append(selectedFeatures, coveredFeature)
+      }
+    }
+  }
+  if len(selectedFeatures) == 0 {
+    return nil, fmt.Errorf("no Features match %q", selection)
+  }
+  covering := s2.CellUnion{}
+  holeCovering := s2.CellUnion{}
+  for _, coveredFeature := range selectedFeatures {
+    if coveredFeature.isHole {
+      holeCovering = s2.CellUnionFromUnion(holeCovering, *coveredFeature.covering)
+    } else {
+      covering = s2.CellUnionFromUnion(covering, *coveredFeature.covering)
+    }
+    holeCovering = s2.CellUnionFromUnion(holeCovering, *coveredFeature.holeCovering)
+  }
+  return s2.CellUnionFromDifference(covering, holeCovering), nil
+}
+
+
+func featureMatchesSelection(coveredFeature CoveredFeature, selection string) bool {
+  if getPathForFeature(coveredFeature.feature) == selection || coveredFeature.name == selection {
+    return true
+  }
+  return coveredFeature.feature.ID != nil && getIdTextForFeature(coveredFeature.feature) == selection
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+  "database/sql"
+  "encoding/json"
+  "os"
+  // Pure Go driver, so that release binaries cross-compiled without cgo can use SQLite
+  _ "modernc.org/sqlite"
+)
+
+
+// Cell ids are stored as signed integers, since SQLite has no unsigned 64 bit type.
+// To find the Features containing a point, convert its leaf cell id to a signed integer and:
+//   SELECT features.path FROM features JOIN cell_ranges ON cell_ranges.feature_id = features.id
+//   WHERE :cellid BETWEEN cell_ranges.range_min AND cell_ranges.range_max AND NOT cell_ranges.is_hole
+const sqliteSchema = `
+CREATE TABLE features (
+  id INTEGER PRIMARY KEY,
+  path TEXT NOT NULL,
+  name TEXT NOT NULL,
+  properties TEXT NOT NULL
+);
+CREATE TABLE cell_ranges (
+  feature_id INTEGER NOT NULL REFERENCES features (id),
+  range_min INTEGER NOT NULL,
+  range_max INTEGER NOT NULL,
+  is_hole INTEGER NOT NULL
+);
+CREATE INDEX cell_ranges_range ON cell_ranges (range_min, range_max);
+CREATE TABLE markers (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  latitude REAL NOT NULL,
+  longitude REAL NOT NULL,
+  cell_id INTEGER NOT NULL,
+  within TEXT NOT NULL,
+  center_within TEXT NOT NULL
+);
+CREATE INDEX markers_cell_id ON markers (cell_id);
+`
+
+
+// Any existing file is replaced. All inserts happen in the returned transaction,
+// which is committed by closeSqliteDatabase.
+func createSqliteDatabase(sqliteFilePath string) (*sql.DB, *sql.Tx) {
+  err := os.Remove(sqliteFilePath)
+  if err != nil && ! os.IsNotExist(err) {
+    check(err)
+  }
+  db, err := sql.Open("sqlite", sqliteFilePath)
+  check(err)
+  _, err = db.Exec(sqliteSchema)
+  check(err)
+  tx, err := db.Begin()
+  check(err)
+  return db, tx
+}
+
+
+func insertSqliteFeature(tx *sql.Tx, path string, name string, properties map[string]interface{}, ranges []CellRange, holeRanges []CellRange, isHole bool) {
+  propertiesJson, err := json.Marshal(properties)
+  check(err)
+  result, err := tx.Exec("INSERT INTO features (path, name, properties) VALUES (?, ?, ?)", path, name, string(propertiesJson))
+  check(err)
+  featureId, err := result.LastInsertId()
+  check(err)
+  for _, cellRange := range ranges {
+    _, err = tx.Exec("INSERT INTO cell_ranges (feature_id, range_min, range_max, is_hole) VALUES (?, ?, ?, ?)", featureId, int64(cellRange.min), int64(cellRange.max), isHole)
+    check(err)
+  }
+  for _, cellRange := range holeRanges {
+    _, err = tx.Exec("INSERT INTO cell_ranges (feature_id, range_min, range_max, is_hole) VALUES (?, ?, ?, ?)", featureId, int64(cellRange.min), int64(cellRange.max), true)
+    check(err)
+  }
+}
+
+
+func insertSqliteMarkers(tx *sql.Tx, markers []Marker) {
+  for _, marker := range markers {
+    withinJson, err := json.Marshal(marker.feature.Properties["within"])
+    check(err)
+    centerWithinJson, err := json.Marshal(marker.feature.Properties["centerwithin"])
+    check(err)
+    lat, lng := marker.feature.Geometry.Point[1], marker.feature.Geometry.Point[0]
+    _, err = tx.Exec(
+      "INSERT INTO markers (name, latitude, longitude, cell_id, within, center_within) VALUES (?, ?, ?, ?, ?, ?)",
+      marker.feature.Properties["name"].(string), lat, lng, int64(*marker.cellId), string(withinJson), string(centerWithinJson))
+    check(err)
+  }
+}
+
+
+func closeSqliteDatabase(db *sql.DB, tx *sql.Tx) {
+  err := tx.Commit()
+  check(err)
+  err = db.Close()
+  check(err)
+}
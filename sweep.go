@@ -0,0 +1,93 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "strconv"
+  "strings"
+)
+
+
+// Covers every Feature with each combination of the given MinLevel, MaxLevel
+// and MaxCells values, writing one row per Feature and combination into sweep.csv
+// and totals per combination into sweep_summary.csv.
+func sweepMain(args []string) {
+  flagSet := flag.NewFlagSet("sweep", flag.ExitOnError)
+  minLevelList := flagSet.String("minlevels", "5,8,10", "Comma separated MinLevel values")
+  maxLevelList := flagSet.String("maxlevels", "14,16,18,20", "Comma separated MaxLevel values")
+  maxCellsList := flagSet.String("maxcells", "100,500,1000", "Comma separated MaxCells values")
+  outputDirectory := flagSet.String("outdir", "output", "Output directory")
+  flagSet.Usage = func() {
+    fmt.Fprintln(os.Stderr, "Usage: osmcoverer sweep [options] <input file>")
+    flagSet.PrintDefaults()
+  }
+  flagSet.Parse(args)
+  if flagSet.NArg() == 0 {
+    flagSet.Usage()
+    os.Exit(2)
+  }
+  minLevels, err := parseIntList(*minLevelList)
+  check(err)
+  maxLevels, err := parseIntList(*maxLevelList)
+  check(err)
+  maxCellsValues, err := parseIntList(*maxCellsList)
+  check(err)
+  os.MkdirAll(*outputDirectory, os.ModePerm)
+
+  features := getFeatureCollectionFromGeojson(flagSet.Arg(0)).Features
+  rows := [][]string{}
+  summaryRows := [][]string{}
+  for _, minLevel := range minLevels {
+    for _, maxLevel := range maxLevels {
+      if minLevel > maxLevel {
+        continue
+      }
+      for _, maxCells := range maxCellsValues {
+        total := CoveringMetrics{cellsByLevel: map[int]int{}}
+        for _, feature := range features {
+          coveredFeature := getCoveredFeature(feature, maxLevel, minLevel, maxCells, EdgeDensify{})
+          metrics := getCoveringMetrics(coveredFeature, maxLevel, minLevel, maxCells)
+          total = addCoveringMetrics(total, metrics)
+          rows = append(rows, append([]string{getPathForFeature(feature), coveredFeature.name}, getSweepColumns(minLevel, maxLevel, maxCells, metrics)...))
+        }
+        summaryRows = append(summaryRows, getSweepColumns(minLevel, maxLevel, maxCells, total))
+        fmt.Println(fmt.Sprintf("MinLevel %d, MaxLevel %d, MaxCells %d: %d cells, %.4f over-coverage, %v", minLevel, maxLevel, maxCells, total.cells, getOverCoverage(total), total.coveringDuration))
+      }
+    }
+  }
+
+  columns := []string{"minlevel", "maxlevel", "maxcells", "cells", "polygonarea", "coveringarea", "areaerror", "overcoverage", "coveringms"}
+  outputRowsToCsv(append([]string{"path", "name"}, columns...), rows, fmt.Sprintf("%s/sweep.csv", *outputDirectory))
+  outputRowsToCsv(columns, summaryRows, fmt.Sprintf("%s/sweep_summary.csv", *outputDirectory))
+}
+
+
+// Area error is the covering area exceeding the polygon area, in square meters.
+func getSweepColumns(minLevel int, maxLevel int, maxCells int, metrics CoveringMetrics) []string {
+  return []string{
+    strconv.Itoa(minLevel),
+    strconv.Itoa(maxLevel),
+    strconv.Itoa(maxCells),
+    strconv.Itoa(metrics.cells),
+    strconv.FormatFloat(metrics.polygonArea, 'f', 2, 64),
+    strconv.FormatFloat(metrics.coveringArea, 'f', 2, 64),
+    strconv.FormatFloat(metrics.coveringArea - metrics.polygonArea, 'f', 2, 64),
+    strconv.FormatFloat(getOverCoverage(metrics), 'f', 4, 64),
+    strconv.FormatFloat(metrics.coveringDuration.Seconds() * 1000, 'f', 3, 64)}
+}
+
+
+func parseIntList(text string) ([]int, error) {
+  values := []int{}
+  for _, part := range strings.Split(text, ",") {
+    value, err := strconv.Atoi(strings.TrimSpace(part))
+    if err != nil {
+      return nil, fmt.Errorf("invalid number %q", part)
+    }
+    values = append(values, value)
+  }
+  return values, nil
+}
+
+
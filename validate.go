@@ -0,0 +1,152 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "math"
+  "os"
+  "github.com/golang/geo/s2"
+  "github.com/paulmach/go.geojson"
+)
+
+
+// Checks the geometry and identification of every Feature without covering them,
+// writing all problems into polygon_problems.csv and the Features with problems
+// that cannot be repaired into invalid_features.geojson. Duplicate vertices, spikes
+// and ring orientation are repaired when covering, so they are only listed in the csv.
+func validateMain(args []string) {
+  flagSet := flag.NewFlagSet("validate", flag.ExitOnError)
+  outputDirectory := flagSet.String("outdir", "output", "Output directory")
+  shouldIndent := flagSet.Bool("pretty", true, "Output pretty printend GeoJSON")
+  flagSet.Usage = func() {
+    fmt.Fprintln(os.Stderr, "Usage: osmcoverer validate [options] <input file>")
+    flagSet.PrintDefaults()
+  }
+  flagSet.Parse(args)
+  if flagSet.NArg() == 0 {
+    flagSet.Usage()
+    os.Exit(2)
+  }
+  os.MkdirAll(*outputDirectory, os.ModePerm)
+
+  features := getFeatureCollectionFromGeojson(flagSet.Arg(0)).Features
+  rows := [][]string{}
+  featureCollection := geojson.NewFeatureCollection()
+  for _, feature := range features {
+    featureRows := getValidationRows(feature)
+    rows = append(rows, featureRows...)
+    problems := []string{}
+    for _, row := range featureRows {
+      if row[3] != "repaired" {
+        problems = append(problems, fmt.Sprintf("%s %s: %s", row[2], row[3], row[4]))
+      }
+    }
+    // Repairable Features are covered correctly, so only the others are offending
+    if len(problems) == 0 {
+      continue
+    }
+    offendingFeature := geojson.NewFeature(feature.Geometry)
+    offendingFeature.ID = feature.ID
+    offendingFeature.Properties = map[string]interface{}{}
+    for key, value := range feature.Properties {
+      offendingFeature.Properties[key] = value
+    }
+    offendingFeature.SetProperty("problems", problems)
+    featureCollection.AddFeature(offendingFeature)
+  }
+
+  outputRowsToCsv(polygonProblemsCsvHeader, rows, fmt.Sprintf("%s/polygon_problems.csv", *outputDirectory))
+  writeGeojsonFile(featureCollection, fmt.Sprintf("%s/invalid_features.geojson", *outputDirectory), *shouldIndent)
+  counts := map[string]int{}
+  for _, row := range rows {
+    counts[row[3]]++
+  }
+  fmt.Println("Features:", len(features))
+  fmt.Println("Invalid Features:", len(featureCollection.Features))
+  fmt.Println("Problems:", counts["invalid"], "invalid,", counts["warning"], "warnings,", counts["repaired"], "repairable")
+}
+
+
+// Adds identification, input, antimeridian and size checks to the ring repairs,
+// self-intersections and validation of getPolygonProblemRows.
+func getValidationRows(feature *geojson.Feature) [][]string {
+  path, name := getPathForFeature(feature), getNameForFeature(feature)
+  rows := [][]string{}
+  if feature.ID == nil {
+    rows = append(rows, []string{path, name, "feature", "warning", "missing id"})
+  }
+  if name == "unnamed" {
+    rows = append(rows, []string{path, name, "feature", "warning", "missing name"})
+  }
+  if feature.Geometry == nil {
+    return append(rows, []string{path, name, "feature", "invalid", "missing geometry"})
+  }
+  err := getFeatureInputError(feature)
+  if err != nil {
+    return append(rows, []string{path, name, "feature", "invalid", err.Error()})
+  }
+  geojsonPolygons := [][][][]float64{}
+  if feature.Geometry.IsPolygon() {
+    geojsonPolygons = append(geojsonPolygons, feature.Geometry.Polygon)
+  } else if feature.Geometry.IsMultiPolygon() {
+    geojsonPolygons = feature.Geometry.MultiPolygon
+  } else if feature.Geometry.IsLineString() {
+    geojsonPolygons = append(geojsonPolygons, [][][]float64{feature.Geometry.LineString})
+  } else {
+    return append(rows, []string{path, name, "feature", "invalid", fmt.Sprintf("unsupported geometry %s", feature.Geometry.Type)})
+  }
+  rows = append(rows, getPolygonProblemRows(feature)...)
+  for polygonIndex, geojsonPolygon := range geojsonPolygons {
+    for ringIndex, ring := range geojsonPolygon {
+      location := fmt.Sprintf("polygon %d ring %d", polygonIndex, ringIndex)
+      if ! feature.Geometry.IsLineString() && (len(ring) < 4 || ! isSamePosition(ring[0], ring[len(ring) - 1])) {
+        rows = append(rows, []string{path, name, location, "invalid", "ring is not closed or has fewer than 4 positions"})
+      }
+      if isCrossingAntimeridian(ring) {
+        rows = append(rows, []string{path, name, location, "warning", "crosses the antimeridian"})
+      }
+      points := []s2.Point{}
+      for _, latlngMap := range ring {
+        points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(latlngMap[1], latlngMap[0])))
+      }
+      points, _ = getRepairedRingPoints(points)
+      if len(points) < 3 {
+        continue
+      }
+      planarArea := getPlanarRingArea(ring)
+      if ringIndex == 0 && planarArea != 0 {
+        // The side of the ring that is inside when drawn on a lat/lng map
+        if planarArea < 0 {
+          points = reverseS2Points(points)
+        }
+        if s2.LoopFromPoints(points).Area() > 2 * math.Pi {
+          rows = append(rows, []string{path, name, location, "warning", "encloses more than a hemisphere, covered as the smaller side"})
+        }
+      }
+    }
+  }
+  return rows
+}
+
+
+// Consecutive positions more than 180 degrees of longitude apart are assumed
+// to be joined across the antimeridian.
+func isCrossingAntimeridian(ring [][]float64) bool {
+  for index := 1; index < len(ring); index++ {
+    if math.Abs(ring[index][0] - ring[index - 1][0]) > 180 {
+      return true
+    }
+  }
+  return false
+}
+
+
+// Shoelace area of the ring in degrees, positive when counterclockwise on a lat/lng map.
+func getPlanarRingArea(ring [][]float64) float64 {
+  area := 0.0
+  for index := range ring {
+    next := ring[(index + 1) % len(ring)]
+    area += ring[index][0] * next[1] - next[0] * ring[index][1]
+  }
+  return area / 2
+}